@@ -2,6 +2,7 @@ package autodiscover
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
@@ -20,6 +21,11 @@ type AutoDiscover interface {
 	Stop() error
 	Config() *Config
 	Key() string
+	Status() Status
+	// Refresh triggers a refresh of the records out of the schedule.
+	Refresh() error
+	// Records returns the discovered records, nil before the first refresh.
+	Records() json.Marshaler
 	creator.GetCreator
 }
 
@@ -29,6 +35,13 @@ type Config struct {
 	Name    string        `yaml:"name"`
 	Type    string        `yaml:"type"`
 	Options ConfigOptions `yaml:"options"`
+	// Module is the name of the module used by the discovered hostScanners.
+	Module string `yaml:"module"`
+	// Ports and ExcludePorts are the port policy of the discovered hostScanners.
+	Ports        string `yaml:"ports"`
+	ExcludePorts string `yaml:"excludePorts"`
+	// Labels are added to every metric of the autoDiscover and the discovered hostScanners.
+	Labels map[string]string `yaml:"labels"`
 }
 
 func (a *Config) Key() string {
@@ -39,7 +52,13 @@ func (a *Config) CompareConfig(b *Config) bool {
 	if a == nil && b == nil {
 		return true
 	}
-	if a != b {
+	if a == nil || b == nil {
+		return false
+	}
+	if a.Name != b.Name || a.Type != b.Type || a.Module != b.Module || a.Ports != b.Ports || a.ExcludePorts != b.ExcludePorts {
+		return false
+	}
+	if !reflect.DeepEqual(a.Labels, b.Labels) {
 		return false
 	}
 	if a.Options == nil && b.Options == nil {
@@ -0,0 +1,59 @@
+package autodiscover
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Status is the refresh status of an AutoDiscover.
+type Status struct {
+	LastRefresh time.Time `json:"lastRefresh"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	LastError   string    `json:"lastError,omitempty"`
+	Records     int       `json:"records"`
+}
+
+// StatusRecorder records the refresh status and the discovered records of an AutoDiscover.
+type StatusRecorder struct {
+	status  Status
+	records json.Marshaler
+	mux     sync.RWMutex
+}
+
+func NewStatusRecorder() *StatusRecorder {
+	return &StatusRecorder{}
+}
+
+// Record records a finished refresh, records is the count of the discovered records.
+func (r *StatusRecorder) Record(err error, records int) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.status.LastRefresh = time.Now()
+	if err != nil {
+		r.status.LastError = err.Error()
+		return
+	}
+	r.status.LastSuccess = r.status.LastRefresh
+	r.status.LastError = ""
+	r.status.Records = records
+}
+
+func (r *StatusRecorder) Status() Status {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.status
+}
+
+// SetRecords records the discovered records, they are encoded as JSON by the API.
+func (r *StatusRecorder) SetRecords(records json.Marshaler) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.records = records
+}
+
+func (r *StatusRecorder) Records() json.Marshaler {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.records
+}
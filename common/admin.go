@@ -0,0 +1,381 @@
+package common
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// adminCreator owns the TLSCheckers and the hostScanners created by the admin API,
+// they are not removed by the config reload.
+type adminCreator struct{}
+
+func (a adminCreator) Describe() string {
+	return "Admin API"
+}
+
+var AdminCreator = adminCreator{}
+
+const (
+	AdminObjectTLSChecker  = "TLSChecker"
+	AdminObjectHostScanner = "hostScanner"
+)
+
+var (
+	ErrAdminObjectNotFound = errors.New("object not found")
+	ErrAdminObjectConflict = errors.New("object is not created by the admin API")
+)
+
+// AdminObject is an object created by the admin API, Spec is the request body
+// which is parsed again when it is restored from the state file.
+type AdminObject struct {
+	Kind string `json:"kind"`
+	Key  string `json:"key"`
+	Spec string `json:"spec"`
+}
+
+// AdminManager creates and deletes the TLSCheckers and the hostScanners at runtime,
+// the requests are authenticated by the bearer token.
+type AdminManager struct {
+	token string
+	// ctx is the context of the created hostScanners.
+	ctx context.Context
+	// objects key is Kind/Key.
+	objects map[string]*AdminObject
+	mux     *sync.RWMutex
+}
+
+func NewAdminManager() *AdminManager {
+	return &AdminManager{
+		ctx:     context.Background(),
+		objects: make(map[string]*AdminObject),
+		mux:     new(sync.RWMutex),
+	}
+}
+
+// Update sets the token, the admin API is disabled when the token is empty.
+func (m *AdminManager) Update(ctx context.Context, token string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.ctx = ctx
+	m.token = token
+}
+
+func (m *AdminManager) authorized(r *http.Request) (enabled bool, authorized bool) {
+	m.mux.RLock()
+	token := m.token
+	m.mux.RUnlock()
+	if token == "" {
+		return false, false
+	}
+	// the token is only accepted in the bearer scheme.
+	got, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return true, false
+	}
+	return true, subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+func (m *AdminManager) context() context.Context {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return m.ctx
+}
+
+func (m *AdminManager) save(kind string, key string, spec []byte) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.objects[kind+"/"+key] = &AdminObject{Kind: kind, Key: key, Spec: string(spec)}
+}
+
+func (m *AdminManager) delete(kind string, key string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	delete(m.objects, kind+"/"+key)
+}
+
+// rename moves the saved object to the new key, the key of a TLSChecker changes with the domain of its module.
+func (m *AdminManager) rename(kind string, oldKey string, newKey string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	o, exists := m.objects[kind+"/"+oldKey]
+	if !exists {
+		return
+	}
+	delete(m.objects, kind+"/"+oldKey)
+	o.Key = newKey
+	m.objects[kind+"/"+newKey] = o
+}
+
+// Objects returns the objects created by the admin API sorted by kind and key.
+func (m *AdminManager) Objects() []*AdminObject {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	objects := make([]*AdminObject, 0, len(m.objects))
+	for _, o := range m.objects {
+		objects = append(objects, o)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].Kind != objects[j].Kind {
+			return objects[i].Kind < objects[j].Kind
+		}
+		return objects[i].Key < objects[j].Key
+	})
+	return objects
+}
+
+// Restore creates the objects saved in the state file.
+func (m *AdminManager) Restore(objects []*AdminObject) {
+	for _, o := range objects {
+		var err error
+		switch o.Kind {
+		case AdminObjectTLSChecker:
+			_, err = m.AddTLSChecker([]byte(o.Spec))
+		case AdminObjectHostScanner:
+			_, err = m.AddHostScanner([]byte(o.Spec))
+		default:
+			err = fmt.Errorf("unknown kind %q", o.Kind)
+		}
+		if err != nil {
+			log.Warn().Msgf("restore admin object %s/%s failed: %v", o.Kind, o.Key, err)
+		}
+	}
+}
+
+// AddTLSChecker creates or replaces a TLSChecker, spec is a TLSChecker of the config file in YAML or JSON.
+func (m *AdminManager) AddTLSChecker(spec []byte) (*TLSChecker, error) {
+	t := &TLSChecker{}
+	if err := yaml.Unmarshal(spec, t); err != nil {
+		return nil, fmt.Errorf("invalid TLSChecker: %w", err)
+	}
+	if t.Host == "" || t.Port == 0 {
+		return nil, errors.New("TLSChecker host and port are required")
+	}
+	if err := validateKnownLabels(t.Labels); err != nil {
+		return nil, fmt.Errorf("invalid TLSChecker labels: %w", err)
+	}
+	Exp.ResolveTLSChecker(t)
+	t.SetDefaultOption()
+	Exp.CheckerRWMutex.RLock()
+	old, exists := Exp.TLSCheckers[t.Key()]
+	Exp.CheckerRWMutex.RUnlock()
+	if exists && old.Creator != AdminCreator {
+		return nil, ErrAdminObjectConflict
+	}
+	Exp.UpdateTLSChecker(m.context(), t, AdminCreator)
+	m.save(AdminObjectTLSChecker, t.Key(), spec)
+	log.Info().Msgf("admin API added tls checker: %s", t.Key())
+	return t, nil
+}
+
+// RemoveTLSChecker removes a TLSChecker created by the admin API.
+func (m *AdminManager) RemoveTLSChecker(key string) error {
+	Exp.CheckerRWMutex.RLock()
+	t, exists := Exp.TLSCheckers[key]
+	Exp.CheckerRWMutex.RUnlock()
+	if !exists {
+		return ErrAdminObjectNotFound
+	}
+	if t.Creator != AdminCreator {
+		return ErrAdminObjectConflict
+	}
+	Exp.RemoveTLSChecker(key)
+	m.delete(AdminObjectTLSChecker, key)
+	return nil
+}
+
+// AddHostScanner creates or replaces a hostScanner, spec is a hostScannersConfig item of the config file in YAML or JSON.
+func (m *AdminManager) AddHostScanner(spec []byte) (*HostScannerConfig, error) {
+	cfg := &HostScannerConfig{}
+	if err := yaml.Unmarshal(spec, cfg); err != nil {
+		return nil, fmt.Errorf("invalid hostScanner: %w", err)
+	}
+	if cfg.Host == "" && cfg.CIDR == "" {
+		return nil, errors.New("hostScanner host or cidr is required")
+	}
+	if err := validateKnownLabels(cfg.Labels); err != nil {
+		return nil, fmt.Errorf("invalid hostScanner labels: %w", err)
+	}
+	Exp.HostScannerRWMutex.RLock()
+	old, exists := Exp.HostScanners[cfg.Key()]
+	Exp.HostScannerRWMutex.RUnlock()
+	if exists && old.Creator != AdminCreator {
+		return nil, ErrAdminObjectConflict
+	}
+	Exp.UpdateHostScannerConfig(m.context(), cfg, AdminCreator)
+	m.save(AdminObjectHostScanner, cfg.Key(), spec)
+	log.Info().Msgf("admin API added hostScanner: %s", cfg.Key())
+	return cfg, nil
+}
+
+// RemoveHostScanner removes a hostScanner created by the admin API.
+func (m *AdminManager) RemoveHostScanner(key string) error {
+	Exp.HostScannerRWMutex.RLock()
+	s, exists := Exp.HostScanners[key]
+	Exp.HostScannerRWMutex.RUnlock()
+	if !exists {
+		return ErrAdminObjectNotFound
+	}
+	if s.Creator != AdminCreator {
+		return ErrAdminObjectConflict
+	}
+	Exp.RemoveHostScanner(key)
+	m.delete(AdminObjectHostScanner, key)
+	return nil
+}
+
+// ProbeTLSChecker probes the TLSChecker immediately, the metrics are dropped.
+func (m *AdminManager) ProbeTLSChecker(key string) (*ProbeResult, error) {
+	Exp.CheckerRWMutex.RLock()
+	t, exists := Exp.TLSCheckers[key]
+	Exp.CheckerRWMutex.RUnlock()
+	if !exists {
+		return nil, ErrAdminObjectNotFound
+	}
+	discardMetrics(func(ch chan<- prometheus.Metric) {
+		Exp.collectTLSChecker(t, ch)
+	})
+	return Exp.Result(key), nil
+}
+
+// RefreshAutoDiscover triggers a refresh of the autoDiscover.
+func (m *AdminManager) RefreshAutoDiscover(name string) error {
+	Exp.AutoDiscoverRWMutex.RLock()
+	a, exists := Exp.AutoDiscover[name]
+	Exp.AutoDiscoverRWMutex.RUnlock()
+	if !exists {
+		return ErrAdminObjectNotFound
+	}
+	log.Info().Msgf("admin API triggered refresh of autoDiscover: %s", name)
+	return a.Refresh()
+}
+
+// Handler serves the admin API, all requests need the header "Authorization: Bearer <adminToken>":
+//   - POST /api/v1/admin/checkers creates a TLSChecker, DELETE /api/v1/admin/checkers?key=xxx deletes it;
+//   - POST /api/v1/admin/hostscanners creates a hostScanner, DELETE /api/v1/admin/hostscanners?key=xxx deletes it;
+//   - POST /api/v1/admin/probe?key=xxx probes a TLSChecker immediately;
+//   - POST /api/v1/admin/autodiscovers/refresh?key=xxx refreshes an autoDiscover.
+func (m *AdminManager) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/admin/checkers", func(w http.ResponseWriter, r *http.Request) {
+		m.handleObject(w, r, func(spec []byte) (interface{}, error) {
+			t, err := m.AddTLSChecker(spec)
+			if err != nil {
+				return nil, err
+			}
+			return newAPITLSChecker(t, nil, false), nil
+		}, m.RemoveTLSChecker)
+	})
+	mux.HandleFunc("/api/v1/admin/hostscanners", func(w http.ResponseWriter, r *http.Request) {
+		m.handleObject(w, r, func(spec []byte) (interface{}, error) {
+			cfg, err := m.AddHostScanner(spec)
+			if err != nil {
+				return nil, err
+			}
+			hostScanners := listAPIHostScanners(cfg.Key()).([]*APIHostScanner)
+			if len(hostScanners) == 0 {
+				return nil, ErrAdminObjectNotFound
+			}
+			return hostScanners[0], nil
+		}, m.RemoveHostScanner)
+	})
+	mux.HandleFunc("/api/v1/admin/probe", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			adminMethodNotAllowed(w, "POST")
+			return
+		}
+		result, err := m.ProbeTLSChecker(r.URL.Query().Get("key"))
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	})
+	mux.HandleFunc("/api/v1/admin/autodiscovers/refresh", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			adminMethodNotAllowed(w, "POST")
+			return
+		}
+		if err := m.RefreshAutoDiscover(r.URL.Query().Get("key")); err != nil {
+			adminError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	return m.Authorize(mux, false)
+}
+
+// Authorize is the middleware of the admin bearer token, next is only served for the authorized requests.
+// The GET requests are served without the token when publicRead is true.
+func (m *AdminManager) Authorize(next http.Handler, publicRead bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !(publicRead && r.Method == http.MethodGet) && !m.checkAuthorized(w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkAuthorized writes 403 when the admin API is disabled and 401 when the token is wrong.
+func (m *AdminManager) checkAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	enabled, authorized := m.authorized(r)
+	if !enabled {
+		http.Error(w, "admin API is disabled, adminToken is not set", http.StatusForbidden)
+		return false
+	}
+	if !authorized {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (m *AdminManager) handleObject(w http.ResponseWriter, r *http.Request, add func([]byte) (interface{}, error), remove func(string) error) {
+	switch r.Method {
+	case http.MethodPost:
+		spec, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		created, err := add(spec)
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, created)
+	case http.MethodDelete:
+		if err := remove(r.URL.Query().Get("key")); err != nil {
+			adminError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		adminMethodNotAllowed(w, "POST, DELETE")
+	}
+}
+
+func adminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrAdminObjectNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrAdminObjectConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func adminMethodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
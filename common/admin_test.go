@@ -0,0 +1,159 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func adminRequest(t *testing.T, method string, path string, token string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	Exp.Admin.Handler().ServeHTTP(rec, r)
+	return rec
+}
+
+func TestAdminAPI(t *testing.T) {
+	old := Exp.Admin
+	Exp.Admin = NewAdminManager()
+	defer func() { Exp.Admin = old }()
+
+	if rec := adminRequest(t, http.MethodPost, "/api/v1/admin/checkers", "", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("admin API should be disabled without token, but now is: %d", rec.Code)
+	}
+	Exp.Admin.Update(context.Background(), "secret")
+	if rec := adminRequest(t, http.MethodPost, "/api/v1/admin/checkers", "wrong", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong token should be unauthorized, but now is: %d", rec.Code)
+	}
+	for _, header := range []string{"secret", "bearer secret", "Basic secret", "Bearer", "Bearer  secret"} {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/admin/checkers", nil)
+		r.Header.Set("Authorization", header)
+		rec := httptest.NewRecorder()
+		Exp.Admin.Handler().ServeHTTP(rec, r)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("authorization %q should be unauthorized, but now is: %d", header, rec.Code)
+		}
+	}
+
+	// the label names must be in the config, the descriptors can not be changed at runtime.
+	for _, spec := range []string{
+		`{"host": "127.0.0.1", "port": 443, "labels": {"unknown": "x"}}`,
+		`{"host": "127.0.0.1", "port": 443, "labels": {"host": "x"}}`,
+	} {
+		if rec := adminRequest(t, http.MethodPost, "/api/v1/admin/checkers", "secret", spec); rec.Code != http.StatusBadRequest {
+			t.Fatalf("checker %s should be a bad request, but now is: %d", spec, rec.Code)
+		}
+	}
+	if rec := adminRequest(t, http.MethodPost, "/api/v1/admin/hostscanners", "secret", `{"host": "127.0.0.1", "labels": {"unknown": "x"}}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("hostScanner with unknown labels should be a bad request, but now is: %d", rec.Code)
+	}
+
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	leaf := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com"}}, ca)
+	port := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{leaf.TLSCertificate()}})
+	spec := fmt.Sprintf(`{"host": "127.0.0.1", "port": %d, "TLSCheckOptions": {"domain": "www.example.com", "skipVerify": true}}`, port)
+	rec := adminRequest(t, http.MethodPost, "/api/v1/admin/checkers", "secret", spec)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create checker status should be 201, but now is: %d %s", rec.Code, rec.Body)
+	}
+	var created APITLSChecker
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	defer Exp.RemoveTLSChecker(created.Key)
+	if created.Origin == nil || created.Origin.Kind != "admin" {
+		t.Fatalf("the origin should be admin, but now is: %+v", created.Origin)
+	}
+	if objects := Exp.Admin.Objects(); len(objects) != 1 || objects[0].Key != created.Key {
+		t.Fatalf("the created checker should be saved, but now is: %v", objects)
+	}
+
+	rec = adminRequest(t, http.MethodPost, "/api/v1/admin/probe?key="+url.QueryEscape(created.Key), "secret", "")
+	var result ProbeResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil || !result.Success {
+		t.Fatalf("the probe should succeed, but now is: %d %+v %v", rec.Code, result, err)
+	}
+
+	// the TLSChecker of the config can not be deleted by the admin API.
+	configChecker := NewTLSChecker(nil, "127.0.0.1", port, TLSCheckOptions{Domain: "config.example.com"})
+	Exp.UpdateTLSChecker(context.Background(), configChecker, &Reloader{})
+	defer Exp.RemoveTLSChecker(configChecker.Key())
+	if rec := adminRequest(t, http.MethodDelete, "/api/v1/admin/checkers?key="+url.QueryEscape(configChecker.Key()), "secret", ""); rec.Code != http.StatusConflict {
+		t.Fatalf("delete checker of the config should be conflict, but now is: %d", rec.Code)
+	}
+
+	if rec := adminRequest(t, http.MethodDelete, "/api/v1/admin/checkers?key="+url.QueryEscape(created.Key), "secret", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete checker status should be 204, but now is: %d %s", rec.Code, rec.Body)
+	}
+	if len(Exp.Admin.Objects()) != 0 {
+		t.Fatalf("the deleted checker should not be saved, but now is: %v", Exp.Admin.Objects())
+	}
+	if rec := adminRequest(t, http.MethodPost, "/api/v1/admin/autodiscovers/refresh?key=missing", "secret", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("refresh missing autoDiscover should be not found, but now is: %d", rec.Code)
+	}
+}
+
+func TestAdminRestore(t *testing.T) {
+	old := Exp.Admin
+	Exp.Admin = NewAdminManager()
+	defer func() { Exp.Admin = old }()
+
+	Exp.Admin.Restore([]*AdminObject{
+		{Kind: AdminObjectTLSChecker, Key: "ignored", Spec: "host: 10.0.0.3\nport: 443\n"},
+		{Kind: AdminObjectTLSChecker, Key: "invalid", Spec: "host: 10.0.0.4\n"},
+	})
+	key := NewTLSChecker(nil, "10.0.0.3", 443, TLSCheckOptions{}).Key()
+	defer Exp.RemoveTLSChecker(key)
+	Exp.CheckerRWMutex.RLock()
+	checker, exists := Exp.TLSCheckers[key]
+	Exp.CheckerRWMutex.RUnlock()
+	if !exists || checker.Creator != AdminCreator {
+		t.Fatalf("the checker should be restored by the admin API, but now is: %v", checker)
+	}
+	if objects := Exp.Admin.Objects(); len(objects) != 1 || objects[0].Key != key {
+		t.Fatalf("only the valid checker should be restored, but now is: %v", objects)
+	}
+}
+
+func TestAdminTLSCheckerModuleReload(t *testing.T) {
+	old := Exp.Admin
+	Exp.Admin = NewAdminManager()
+	defer func() { Exp.Admin = old }()
+	Exp.Admin.Update(context.Background(), "secret")
+	defer Exp.SetModules(nil)
+
+	Exp.SetModules(map[string]TLSCheckOptions{"internal": {Timeout: 1000}})
+	rec := adminRequest(t, http.MethodPost, "/api/v1/admin/checkers", "secret", `{"host": "127.0.0.1", "port": 443, "module": "internal"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create checker status should be 201, but now is: %d %s", rec.Code, rec.Body)
+	}
+	created := &TLSChecker{Host: "127.0.0.1", Port: 443, TLSCheckOptions: TLSCheckOptions{Domain: "127.0.0.1"}}
+	defer Exp.RemoveTLSChecker(created.Key())
+
+	// the module sets the domain, so the key of the TLSChecker changes.
+	changed := Exp.SetModules(map[string]TLSCheckOptions{"internal": {Domain: "internal.example.com", Timeout: 2000}})
+	Exp.ReloadModuleDependents(changed)
+	reloaded := &TLSChecker{Host: "127.0.0.1", Port: 443, TLSCheckOptions: TLSCheckOptions{Domain: "internal.example.com"}}
+	defer Exp.RemoveTLSChecker(reloaded.Key())
+	Exp.CheckerRWMutex.RLock()
+	_, oldExists := Exp.TLSCheckers[created.Key()]
+	checker := Exp.TLSCheckers[reloaded.Key()]
+	Exp.CheckerRWMutex.RUnlock()
+	if oldExists || checker == nil || checker.Timeout != 2000 || checker.Creator != AdminCreator {
+		t.Fatalf("the admin TLSChecker should be reloaded with the module, but now is: %+v", checker)
+	}
+	if objects := Exp.Admin.Objects(); len(objects) != 1 || objects[0].Key != reloaded.Key() {
+		t.Fatalf("the saved object should be renamed to %s, but now is: %v", reloaded.Key(), objects)
+	}
+}
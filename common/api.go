@@ -0,0 +1,281 @@
+package common
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+	"tlsprobe/autodiscover"
+	"tlsprobe/common/creator"
+)
+
+// APIOrigin is the object which creates a hostScanner, a TLSChecker or an autoDiscover.
+type APIOrigin struct {
+	// Kind is config, hostScanner, autoDiscover, admin or probe.
+	Kind     string `json:"kind"`
+	Describe string `json:"describe"`
+}
+
+func newAPIOrigin(c creator.Creator) *APIOrigin {
+	if c == nil {
+		return nil
+	}
+	origin := &APIOrigin{Describe: c.Describe()}
+	switch c.(type) {
+	case *Reloader:
+		origin.Kind = "config"
+	case *HostScanner:
+		origin.Kind = "hostScanner"
+	case autodiscover.AutoDiscover:
+		origin.Kind = "autoDiscover"
+	case probeCreator:
+		origin.Kind = "probe"
+	case adminCreator:
+		origin.Kind = "admin"
+	default:
+		origin.Kind = "unknown"
+	}
+	return origin
+}
+
+type APIScanProgress struct {
+	Scanned    uint64     `json:"scanned"`
+	Total      uint64     `json:"total"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+type APIHostScanner struct {
+	Key          string     `json:"key"`
+	Host         string     `json:"host"`
+	CIDR         string     `json:"cidr,omitempty"`
+	Domain       string     `json:"domain"`
+	Module       string     `json:"module,omitempty"`
+	Ports        string     `json:"ports,omitempty"`
+	ExcludePorts string     `json:"excludePorts,omitempty"`
+	Labels       Labels     `json:"labels,omitempty"`
+	Origin       *APIOrigin `json:"origin"`
+	// KnownPorts are the discovered TLS ports.
+	KnownPorts []uint `json:"knownPorts"`
+	// Progress is the last full scan of the host, it is nil before the first full scan.
+	Progress *APIScanProgress `json:"progress,omitempty"`
+	// Children are the hostScanners of every host of the CIDR.
+	Children []*APIHostScanner `json:"children,omitempty"`
+}
+
+func newAPIHostScanner(s *HostScanner) *APIHostScanner {
+	s.mux.RLock()
+	children := append([]*HostScanner{}, s.children...)
+	s.mux.RUnlock()
+	hs := &APIHostScanner{
+		Key:          s.Config.Key(),
+		Host:         s.Config.Host,
+		CIDR:         s.Config.CIDR,
+		Domain:       s.Config.TLSOptions.Domain,
+		Module:       s.Config.Module,
+		Ports:        s.Config.Ports,
+		ExcludePorts: s.Config.ExcludePorts,
+		Labels:       s.Config.Labels,
+		Origin:       newAPIOrigin(s.Creator),
+		KnownPorts:   s.KnownPorts(),
+	}
+	if startedAt, finishedAt := s.progress.Times(); !startedAt.IsZero() {
+		scanned, total := s.progress.Get()
+		hs.Progress = &APIScanProgress{Scanned: scanned, Total: total, StartedAt: startedAt}
+		if !finishedAt.IsZero() {
+			hs.Progress.FinishedAt = &finishedAt
+		}
+	}
+	for _, child := range children {
+		hs.Children = append(hs.Children, newAPIHostScanner(child))
+	}
+	return hs
+}
+
+type APICertificate struct {
+	Fingerprint string    `json:"fingerprint"`
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Serial      string    `json:"serial"`
+	DNSNames    []string  `json:"dnsNames"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	// UpdatedAt is the time of the last probe which got the certificate.
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type APITLSChecker struct {
+	Key    string     `json:"key"`
+	Host   string     `json:"host"`
+	Port   uint       `json:"port"`
+	Domain string     `json:"domain"`
+	Module string     `json:"module,omitempty"`
+	Owner  string     `json:"owner,omitempty"`
+	Labels Labels     `json:"labels,omitempty"`
+	Origin *APIOrigin `json:"origin"`
+	// SkipVerify is true when the certificates are not verified.
+	SkipVerify bool `json:"skipVerify"`
+	// Status is the status of tlsprobe_cert_status, it is unknown before the first probe.
+	Status string `json:"status"`
+	// ExpiresInDays is the days before NotAfter of the certificate, it is negative when the certificate is expired.
+	ExpiresInDays *int `json:"expiresInDays"`
+	// LastResult is nil before the first probe.
+	LastResult *ProbeResult `json:"lastResult"`
+	// Certificate is the leaf certificate of the last successful probe.
+	Certificate *APICertificate `json:"certificate"`
+	// Chain and History are only returned when the TLSChecker is queried by key.
+	Chain   []*APICertificate `json:"chain,omitempty"`
+	History []*ProbeResult    `json:"history,omitempty"`
+}
+
+// CertStatusUnknown is the status of a TLSChecker which is never probed.
+const CertStatusUnknown = "unknown"
+
+func newAPICertificate(cert *x509.Certificate, updatedAt time.Time) *APICertificate {
+	return &APICertificate{
+		Fingerprint: Fingerprint(cert),
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		Serial:      cert.SerialNumber.Text(16),
+		DNSNames:    cert.DNSNames,
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+		UpdatedAt:   updatedAt,
+	}
+}
+
+func newAPITLSChecker(t *TLSChecker, endpoint *InventoryEndpoint, detail bool) *APITLSChecker {
+	checker := &APITLSChecker{
+		Key:        t.Key(),
+		Host:       t.Host,
+		Port:       t.Port,
+		Domain:     t.TLSCheckOptions.Domain,
+		Module:     t.Module,
+		Owner:      t.GetOwner(),
+		Labels:     t.Labels,
+		Origin:     newAPIOrigin(t.Creator),
+		SkipVerify: t.InsecureSkipVerify,
+		Status:     CertStatusUnknown,
+		LastResult: Exp.Result(t.Key()),
+	}
+	if endpoint != nil {
+		checker.Certificate = newAPICertificate(endpoint.Certificate, endpoint.UpdatedAt)
+		expiresIn := time.Until(endpoint.Certificate.NotAfter)
+		days := int(math.Floor(expiresIn.Hours() / 24))
+		checker.ExpiresInDays = &days
+		checker.Status = t.ExpiryThresholds().Status(expiresIn)
+	}
+	// like tlsprobe_cert_status, a failed probe is error unless the certificate is expired.
+	if checker.LastResult != nil && !checker.LastResult.Success && checker.Status != CertStatusExpired {
+		checker.Status = CertStatusError
+	}
+	if detail {
+		if endpoint != nil {
+			chain := endpoint.Chain
+			if len(chain) == 0 {
+				chain = []*x509.Certificate{endpoint.Certificate}
+			}
+			for _, cert := range chain {
+				checker.Chain = append(checker.Chain, newAPICertificate(cert, endpoint.UpdatedAt))
+			}
+		}
+		checker.History = Exp.History(t.Key())
+	}
+	return checker
+}
+
+type APIAutoDiscover struct {
+	Name         string              `json:"name"`
+	Type         string              `json:"type"`
+	Module       string              `json:"module,omitempty"`
+	Ports        string              `json:"ports,omitempty"`
+	ExcludePorts string              `json:"excludePorts,omitempty"`
+	Labels       map[string]string   `json:"labels,omitempty"`
+	Origin       *APIOrigin          `json:"origin"`
+	Status       autodiscover.Status `json:"status"`
+	// Records is the record tree of the last refresh, the options are never exported because of the secrets.
+	Records json.Marshaler `json:"records"`
+}
+
+func newAPIAutoDiscover(a autodiscover.AutoDiscover) *APIAutoDiscover {
+	cfg := a.Config()
+	return &APIAutoDiscover{
+		Name:         cfg.Name,
+		Type:         cfg.Type,
+		Module:       cfg.Module,
+		Ports:        cfg.Ports,
+		ExcludePorts: cfg.ExcludePorts,
+		Labels:       cfg.Labels,
+		Origin:       newAPIOrigin(a.GetCreator()),
+		Status:       a.Status(),
+		Records:      a.Records(),
+	}
+}
+
+// APIHandler serves the read-only JSON API of the exporter's state:
+// /api/v1/hostscanners, /api/v1/checkers and /api/v1/autodiscovers.
+func APIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/hostscanners", apiGet(listAPIHostScanners))
+	mux.HandleFunc("/api/v1/checkers", apiGet(listAPITLSCheckers))
+	mux.HandleFunc("/api/v1/autodiscovers", apiGet(listAPIAutoDiscovers))
+	return mux
+}
+
+// apiGet only allows the GET requests, the objects are filtered by the query "key" when it is set.
+func apiGet(list func(key string) interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, list(r.URL.Query().Get("key")))
+	}
+}
+
+func listAPIHostScanners(key string) interface{} {
+	Exp.HostScannerRWMutex.RLock()
+	scanners := make([]*HostScanner, 0, len(Exp.HostScanners))
+	for k, s := range Exp.HostScanners {
+		if key == "" || key == k {
+			scanners = append(scanners, s)
+		}
+	}
+	Exp.HostScannerRWMutex.RUnlock()
+	hostScanners := make([]*APIHostScanner, 0, len(scanners))
+	for _, s := range scanners {
+		hostScanners = append(hostScanners, newAPIHostScanner(s))
+	}
+	sort.Slice(hostScanners, func(i, j int) bool { return hostScanners[i].Key < hostScanners[j].Key })
+	return hostScanners
+}
+
+func listAPITLSCheckers(key string) interface{} {
+	endpoints := Exp.Inventory.Endpoints()
+	Exp.CheckerRWMutex.RLock()
+	checkers := make([]*APITLSChecker, 0, len(Exp.TLSCheckers))
+	for k, t := range Exp.TLSCheckers {
+		if key == "" || key == k {
+			checkers = append(checkers, newAPITLSChecker(t, endpoints[k], key != ""))
+		}
+	}
+	Exp.CheckerRWMutex.RUnlock()
+	sort.Slice(checkers, func(i, j int) bool { return checkers[i].Key < checkers[j].Key })
+	return checkers
+}
+
+func listAPIAutoDiscovers(key string) interface{} {
+	Exp.AutoDiscoverRWMutex.RLock()
+	defer Exp.AutoDiscoverRWMutex.RUnlock()
+	autoDiscovers := make([]*APIAutoDiscover, 0, len(Exp.AutoDiscover))
+	for name, a := range Exp.AutoDiscover {
+		if key == "" || key == name {
+			autoDiscovers = append(autoDiscovers, newAPIAutoDiscover(a))
+		}
+	}
+	sort.Slice(autoDiscovers, func(i, j int) bool { return autoDiscovers[i].Name < autoDiscovers[j].Name })
+	return autoDiscovers
+}
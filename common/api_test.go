@@ -0,0 +1,116 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+	"tlsprobe/autodiscover"
+	"tlsprobe/common/creator"
+)
+
+// testAutoDiscover is an autoDiscover whose records are fixed.
+type testAutoDiscover struct {
+	cfg     *autodiscover.Config
+	records json.RawMessage
+}
+
+func (a *testAutoDiscover) Start() error                 { return nil }
+func (a *testAutoDiscover) Stop() error                  { return nil }
+func (a *testAutoDiscover) Config() *autodiscover.Config { return a.cfg }
+func (a *testAutoDiscover) Key() string                  { return a.cfg.Key() }
+func (a *testAutoDiscover) Status() autodiscover.Status  { return autodiscover.Status{Records: 1} }
+func (a *testAutoDiscover) Refresh() error               { return nil }
+func (a *testAutoDiscover) Records() json.Marshaler      { return a.records }
+func (a *testAutoDiscover) GetCreator() creator.Creator  { return nil }
+func (a *testAutoDiscover) Describe() string             { return "AutoDiscover: " + a.cfg.Name }
+
+func getAPI(t *testing.T, path string, v interface{}) {
+	rec := httptest.NewRecorder()
+	APIHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s status should be 200, but now is: %d %s", path, rec.Code, rec.Body)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s decode failed: %v", path, err)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	leaf := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com"}}, ca)
+
+	cfg := HostScannerConfig{Host: "10.0.0.2", TLSOptions: TLSCheckOptions{Domain: "www.example.com"}}
+	s := NewHostScanner(nil, &cfg, &cfg, NewWaitPool(1), context.Background())
+	checker := NewTLSChecker(s, cfg.Host, 443, cfg.TLSOptions)
+	s.Ports[443] = checker
+	Exp.UpdateTLSChecker(context.Background(), checker, s)
+	defer Exp.RemoveTLSChecker(checker.Key())
+	Exp.Inventory.Observe(checker, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf.cert}}, nil)
+	Exp.setResult(checker, time.Now(), nil, nil)
+	Exp.HostScannerRWMutex.Lock()
+	Exp.HostScanners[cfg.Key()] = s
+	Exp.HostScannerRWMutex.Unlock()
+	defer Exp.RemoveHostScanner(cfg.Key())
+	Exp.AutoDiscoverRWMutex.Lock()
+	Exp.AutoDiscover["test"] = &testAutoDiscover{cfg: &autodiscover.Config{Name: "test", Type: "test"}, records: json.RawMessage(`{"com":{}}`)}
+	Exp.AutoDiscoverRWMutex.Unlock()
+	defer func() {
+		Exp.AutoDiscoverRWMutex.Lock()
+		delete(Exp.AutoDiscover, "test")
+		Exp.AutoDiscoverRWMutex.Unlock()
+	}()
+
+	var hostScanners []*APIHostScanner
+	getAPI(t, "/api/v1/hostscanners?key="+url.QueryEscape(cfg.Key()), &hostScanners)
+	if len(hostScanners) != 1 || len(hostScanners[0].KnownPorts) != 1 || hostScanners[0].KnownPorts[0] != 443 {
+		t.Fatalf("hostScanner with known port 443 should be listed, but now is: %+v", hostScanners)
+	}
+
+	var checkers []*APITLSChecker
+	getAPI(t, "/api/v1/checkers?key="+url.QueryEscape(checker.Key()), &checkers)
+	if len(checkers) != 1 {
+		t.Fatalf("one checker should be listed, but now is: %d", len(checkers))
+	}
+	c := checkers[0]
+	if c.Origin == nil || c.Origin.Kind != "hostScanner" || c.Origin.Describe != s.Describe() {
+		t.Fatalf("the origin should be the hostScanner, but now is: %+v", c.Origin)
+	}
+	if c.LastResult == nil || !c.LastResult.Success {
+		t.Fatalf("the last result should be success, but now is: %+v", c.LastResult)
+	}
+	if c.Certificate == nil || c.Certificate.Fingerprint != Fingerprint(leaf.cert) {
+		t.Fatalf("the certificate should be listed, but now is: %+v", c.Certificate)
+	}
+	if c.Status != CertStatusCritical || c.ExpiresInDays == nil || *c.ExpiresInDays != 0 {
+		t.Fatalf("the certificate expiring in a day should be critical, but now is: %s %v", c.Status, c.ExpiresInDays)
+	}
+	if len(c.Chain) != 1 || len(c.History) != 1 {
+		t.Fatalf("the chain and the history should be returned by key, but now is: %d %d", len(c.Chain), len(c.History))
+	}
+	var all []*APITLSChecker
+	getAPI(t, "/api/v1/checkers", &all)
+	for _, c := range all {
+		if len(c.Chain) > 0 || len(c.History) > 0 {
+			t.Fatalf("the chain and the history should only be returned by key: %+v", c)
+		}
+	}
+
+	var autoDiscovers []map[string]interface{}
+	getAPI(t, "/api/v1/autodiscovers?key=test", &autoDiscovers)
+	if len(autoDiscovers) != 1 || autoDiscovers[0]["records"] == nil {
+		t.Fatalf("the autoDiscover records should be listed, but now is: %v", autoDiscovers)
+	}
+
+	rec := httptest.NewRecorder()
+	APIHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/checkers", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST status should be 405, but now is: %d", rec.Code)
+	}
+}
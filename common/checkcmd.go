@@ -0,0 +1,329 @@
+package common
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+	"io"
+	"math"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// CheckResult is the result of a target checked by the check command.
+type CheckResult struct {
+	Target     string `json:"target"`
+	Host       string `json:"host"`
+	Port       uint   `json:"port"`
+	Domain     string `json:"domain"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"errorClass,omitempty"`
+	// Status is the status of tlsprobe_cert_status.
+	Status        string `json:"status"`
+	ExpiresInDays *int   `json:"expiresInDays"`
+	// Verification is verified, failed or skipped.
+	Verification string `json:"verification"`
+	VerifyError  string `json:"verifyError,omitempty"`
+	// LegacyVerification is verified or failed, it is empty when --legacy-ca-file is not set.
+	LegacyVerification string `json:"legacyVerification,omitempty"`
+	LegacyVerifyError  string `json:"legacyVerifyError,omitempty"`
+	TLSVersion         string `json:"tlsVersion,omitempty"`
+	CipherSuite        string `json:"cipherSuite,omitempty"`
+	ALPN               string `json:"alpn,omitempty"`
+	// Chain is the verified chain, or the certificates sent by the server when it is not verified.
+	Chain []*APICertificate `json:"chain"`
+	// DefaultCert is the certificate got by --default-cert.
+	DefaultCert      *APICertificate `json:"defaultCert,omitempty"`
+	DefaultCertError string          `json:"defaultCertError,omitempty"`
+}
+
+const (
+	VerificationVerified = "verified"
+	VerificationFailed   = "failed"
+	VerificationSkipped  = "skipped"
+)
+
+// ParseCheckTarget parses host:port[/sni], the SNI defaults to the host.
+func ParseCheckTarget(target string) (host string, port uint, sni string, err error) {
+	addr := target
+	if i := strings.Index(target, "/"); i >= 0 {
+		addr, sni = target[:i], target[i+1:]
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, "", fmt.Errorf("invalid target %q: %w", target, err)
+	}
+	p, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || p == 0 {
+		return "", 0, "", fmt.Errorf("invalid target port %q", portStr)
+	}
+	if sni == "" {
+		sni = host
+	}
+	return host, uint(p), sni, nil
+}
+
+// CheckTarget runs a TLSChecker once, the verify error does not stop collecting the negotiated parameters.
+func CheckTarget(target string, options TLSCheckOptions) *CheckResult {
+	result := &CheckResult{Target: target, Status: CertStatusError}
+	host, port, sni, err := ParseCheckTarget(target)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	options.Domain = sni
+	checker := NewTLSChecker(nil, host, port, options)
+	result.Host, result.Port, result.Domain = host, port, sni
+
+	conn, err := TCPConnect(checker.Addr(), &net.Dialer{Timeout: checker.GetTimeout()}, checker.ReTryTimes)
+	if err != nil {
+		err = fmt.Errorf("tls check error: %w", err)
+		result.Error, result.ErrorClass = err.Error(), ErrorClass(err)
+		return result
+	}
+	stat, err := checker.CheckWithConn(conn)
+	if err != nil {
+		result.Error, result.ErrorClass = err.Error(), ErrorClass(err)
+	}
+	if stat == nil {
+		return result
+	}
+	result.TLSVersion = tls.VersionName(stat.Version)
+	result.CipherSuite = tls.CipherSuiteName(stat.CipherSuite)
+	result.ALPN = stat.NegotiatedProtocol
+
+	chain := stat.PeerCertificates
+	switch {
+	case checker.InsecureSkipVerify:
+		result.Verification = VerificationSkipped
+	case err == nil:
+		result.Verification = VerificationVerified
+		chain = stat.VerifiedChains[0]
+	default:
+		result.Verification = VerificationFailed
+		if verr := errors.Unwrap(err); verr != nil {
+			result.VerifyError = verr.Error()
+		}
+	}
+	now := time.Now()
+	for _, cert := range chain {
+		result.Chain = append(result.Chain, newAPICertificate(cert, now))
+	}
+	if checker.LegacyCAFile != "" {
+		result.LegacyVerification = VerificationVerified
+		if _, lerr := VerifyLegacyCertificates(stat.PeerCertificates, checker.LegacyCAFile); lerr != nil {
+			result.LegacyVerification = VerificationFailed
+			result.LegacyVerifyError = lerr.Error()
+		}
+	}
+	if checker.DefaultCert != "" {
+		if defaultStat, derr := checker.CheckDefaultCert(); derr != nil {
+			result.DefaultCertError = derr.Error()
+		} else {
+			result.DefaultCert = newAPICertificate(defaultStat.PeerCertificates[0], now)
+		}
+	}
+
+	expiresIn := time.Until(stat.PeerCertificates[0].NotAfter)
+	days := int(math.Floor(expiresIn.Hours() / 24))
+	result.ExpiresInDays = &days
+	result.Success = err == nil
+	// like tlsprobe_cert_status, a failed check is error unless the certificate is expired.
+	if status := checker.ExpiryThresholds().Status(expiresIn); err == nil || status == CertStatusExpired {
+		result.Status = status
+	}
+	return result
+}
+
+// certStatusSeverity orders the statuses which fail the check command.
+var certStatusSeverity = map[string]int{
+	CertStatusOK:       0,
+	CertStatusWarning:  1,
+	CertStatusCritical: 2,
+	CertStatusExpired:  3,
+	CertStatusError:    4,
+}
+
+// RunCheckCommand runs "tlsprobe check [flags] host:port[/sni]...", it returns the exit code:
+// 0 when all targets are fine, 1 when a check failed or a certificate reached --fail-on, 2 when the usage is invalid.
+func RunCheckCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := pflag.NewFlagSet("check", pflag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: tlsprobe check [flags] host:port[/sni]...")
+		flags.PrintDefaults()
+	}
+	var flagOptions TLSCheckOptions
+	flags.UintVar(&flagOptions.Timeout, "timeout", 3000, "timeout in milliseconds.")
+	flags.UintVar(&flagOptions.ReTryTimes, "retry", 0, "retry times of the timed out connections.")
+	flags.BoolVar(&flagOptions.InsecureSkipVerify, "skip-verify", false, "do not verify the certificates.")
+	flags.StringVar(&flagOptions.CAFile, "ca-file", "", "PEM bundle of the roots, the system roots are used when it is empty.")
+	flags.StringVar(&flagOptions.LegacyCAFile, "legacy-ca-file", "", "PEM bundle of the roots trusted by the legacy clients.")
+	flags.StringVar(&flagOptions.DefaultCert, "default-cert", "", "also check the default certificate: noSNI or bogusSNI.")
+	flags.UintVar(&flagOptions.WarningDays, "warning-days", DefaultWarningDays, "days before NotAfter when a certificate turns warning.")
+	flags.UintVar(&flagOptions.CriticalDays, "critical-days", DefaultCriticalDays, "days before NotAfter when a certificate turns critical.")
+	configFilename := flags.StringP("config", "c", "./config.yml", "config name, its modules and expiry thresholds are used.")
+	module := flags.String("module", "", "module of the config whose options are used, the flags override it.")
+	failOn := flags.String("fail-on", CertStatusWarning, "the lowest certificate status which fails the check: warning, critical or expired.")
+	output := flags.StringP("output", "o", "table", "output format: table or json.")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	threshold, exists := certStatusSeverity[*failOn]
+	if !exists || *failOn == CertStatusOK || *failOn == CertStatusError {
+		fmt.Fprintf(stderr, "invalid --fail-on %q\n", *failOn)
+		return 2
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(stderr, "invalid --output %q\n", *output)
+		return 2
+	}
+	if d := flagOptions.DefaultCert; !ValidDefaultCert(d) {
+		fmt.Fprintf(stderr, "invalid --default-cert %q\n", d)
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	options := changedCheckOptions(flags, flagOptions)
+	if *module != "" || flags.Changed("config") {
+		cfg, err := loadCheckConfig(*configFilename)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		Exp.SetExpiryDefaults(cfg.ExpiryThresholds)
+		if *module != "" {
+			m, exists := cfg.Modules[*module]
+			if !exists {
+				fmt.Fprintf(stderr, "module %s not found in %s\n", *module, *configFilename)
+				return 2
+			}
+			options = MergeTLSCheckOptions(options, m)
+		}
+	}
+
+	results := make([]*CheckResult, 0, flags.NArg())
+	code := 0
+	for _, target := range flags.Args() {
+		result := CheckTarget(target, options)
+		results = append(results, result)
+		if !result.Success || certStatusSeverity[result.Status] >= threshold {
+			code = 1
+		}
+	}
+	if *output == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		return code
+	}
+	writeCheckTable(stdout, results)
+	return code
+}
+
+// checkOptionFlags maps the flags of the check command to the yaml names of TLSCheckOptions.
+var checkOptionFlags = map[string]string{
+	"timeout":        "timeout",
+	"retry":          "reTryTimes",
+	"skip-verify":    "skipVerify",
+	"ca-file":        "caFile",
+	"legacy-ca-file": "legacyCAFile",
+	"default-cert":   "defaultCert",
+	"warning-days":   "warningDays",
+	"critical-days":  "criticalDays",
+}
+
+// changedCheckOptions returns the options of the flags given on the command line, they are marked set
+// so they override the module. The flags not given are left zero for the module and the defaults.
+func changedCheckOptions(flags *pflag.FlagSet, flagOptions TLSCheckOptions) TLSCheckOptions {
+	var options TLSCheckOptions
+	from, to := reflect.ValueOf(flagOptions), reflect.ValueOf(&options).Elem()
+	for flag, name := range checkOptionFlags {
+		if !flags.Changed(flag) {
+			continue
+		}
+		i := tlsCheckOptionsFields[name]
+		to.Field(i).Set(from.Field(i))
+		options.markSet(name)
+	}
+	return options
+}
+
+func loadCheckConfig(filename string) (Config, error) {
+	cfg := DefaultConfig()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse config %s failed: %w", filename, err)
+	}
+	return cfg, nil
+}
+
+func writeCheckTable(out io.Writer, results []*CheckResult) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for i, r := range results {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "TARGET\t%s\n", r.Target)
+		if r.Success {
+			fmt.Fprintf(w, "RESULT\tok\n")
+		} else {
+			fmt.Fprintf(w, "RESULT\tfailed: %s\n", r.Error)
+		}
+		if r.ExpiresInDays != nil {
+			fmt.Fprintf(w, "STATUS\t%s, %d days left, NotAfter %s\n", r.Status, *r.ExpiresInDays, r.Chain[0].NotAfter.Format(time.RFC3339))
+		} else {
+			fmt.Fprintf(w, "STATUS\t%s\n", r.Status)
+		}
+		if r.Verification == "" {
+			continue
+		}
+		if r.VerifyError != "" {
+			fmt.Fprintf(w, "VERIFICATION\t%s: %s\n", r.Verification, r.VerifyError)
+		} else {
+			fmt.Fprintf(w, "VERIFICATION\t%s\n", r.Verification)
+		}
+		fmt.Fprintf(w, "PROTOCOL\t%s, %s", r.TLSVersion, r.CipherSuite)
+		if r.ALPN != "" {
+			fmt.Fprintf(w, ", ALPN %s", r.ALPN)
+		}
+		fmt.Fprintln(w)
+		if r.LegacyVerifyError != "" {
+			fmt.Fprintf(w, "LEGACY\t%s: %s\n", r.LegacyVerification, r.LegacyVerifyError)
+		} else if r.LegacyVerification != "" {
+			fmt.Fprintf(w, "LEGACY\t%s\n", r.LegacyVerification)
+		}
+		for j, cert := range r.Chain {
+			fmt.Fprintf(w, "CHAIN %d\t%s\n", j, cert.Subject)
+			fmt.Fprintf(w, "\tissuer: %s\n", cert.Issuer)
+			fmt.Fprintf(w, "\tvalid: %s - %s\n", cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339))
+			fmt.Fprintf(w, "\tfingerprint: %s\n", cert.Fingerprint)
+		}
+		if r.DefaultCertError != "" {
+			fmt.Fprintf(w, "DEFAULT CERT\tfailed: %s\n", r.DefaultCertError)
+		} else if r.DefaultCert != nil {
+			fmt.Fprintf(w, "DEFAULT CERT\t%s\n", r.DefaultCert.Subject)
+			fmt.Fprintf(w, "\tvalid: %s - %s\n", r.DefaultCert.NotBefore.Format(time.RFC3339), r.DefaultCert.NotAfter.Format(time.RFC3339))
+			fmt.Fprintf(w, "\tfingerprint: %s\n", r.DefaultCert.Fingerprint)
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,166 @@
+package common
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCheckTarget(t *testing.T) {
+	host, port, sni, err := ParseCheckTarget("127.0.0.1:8443/www.example.com")
+	if err != nil || host != "127.0.0.1" || port != 8443 || sni != "www.example.com" {
+		t.Fatalf("got %s %d %s %v", host, port, sni, err)
+	}
+	host, port, sni, err = ParseCheckTarget("[::1]:443")
+	if err != nil || host != "::1" || port != 443 || sni != "::1" {
+		t.Fatalf("got %s %d %s %v", host, port, sni, err)
+	}
+	for _, target := range []string{"www.example.com", "www.example.com:https", "www.example.com:0"} {
+		if _, _, _, err := ParseCheckTarget(target); err == nil {
+			t.Fatalf("%s should be invalid", target)
+		}
+	}
+}
+
+func TestRunCheckCommand(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	caFile := writeCAFile(t, ca)
+	leaf := newTestCert(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "www.example.com"},
+		DNSNames: []string{"www.example.com"},
+		NotAfter: time.Now().Add(60 * 24 * time.Hour),
+	}, ca)
+	expiring := newTestCert(t, &x509.Certificate{
+		DNSNames: []string{"expiring.example.com"},
+		NotAfter: time.Now().Add(10 * 24 * time.Hour),
+	}, ca)
+	port := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{leaf.TLSCertificate(), expiring.TLSCertificate()}})
+	target := fmt.Sprintf("127.0.0.1:%d/www.example.com", port)
+	expiringTarget := fmt.Sprintf("127.0.0.1:%d/expiring.example.com", port)
+
+	run := func(args ...string) (int, string) {
+		var stdout, stderr bytes.Buffer
+		code := RunCheckCommand(args, &stdout, &stderr)
+		return code, stdout.String()
+	}
+
+	code, out := run("--ca-file", caFile, target)
+	if code != 0 {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	for _, s := range []string{"RESULT        ok", "verified", "TLS 1.3", "CN=www.example.com", "CN=test ca"} {
+		if !strings.Contains(out, s) {
+			t.Fatalf("output should contain %q:\n%s", s, out)
+		}
+	}
+
+	// the untrusted chain fails unless verify is skipped.
+	if code, out = run(target); code != 1 || !strings.Contains(out, "VERIFICATION  failed") {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if code, out = run("--skip-verify", target); code != 0 || !strings.Contains(out, "skipped") {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+
+	// the expiring certificate is warning, which fails only when it reaches --fail-on.
+	if code, _ = run("--ca-file", caFile, expiringTarget); code != 1 {
+		t.Fatalf("exit code %d", code)
+	}
+	if code, _ = run("--ca-file", caFile, "--fail-on", "critical", expiringTarget); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	if code, _ = run("--ca-file", caFile, "--critical-days", "14", "--fail-on", "critical", expiringTarget); code != 1 {
+		t.Fatalf("exit code %d", code)
+	}
+
+	code, out = run("--ca-file", caFile, "-o", "json", target, "127.0.0.1:1")
+	if code != 1 {
+		t.Fatalf("exit code %d", code)
+	}
+	var results []*CheckResult
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results", len(results))
+	}
+	if r := results[0]; !r.Success || r.Status != CertStatusOK || r.Verification != VerificationVerified || len(r.Chain) != 2 || *r.ExpiresInDays < 59 {
+		t.Fatalf("unexpected result %+v", r)
+	}
+	if r := results[1]; r.Success || r.Status != CertStatusError || r.ErrorClass == "" {
+		t.Fatalf("unexpected result %+v", r)
+	}
+
+	for _, args := range [][]string{{}, {"--output", "yaml", target}, {"--fail-on", "ok", target}, {"--unknown", target}} {
+		if code, _ = run(args...); code != 2 {
+			t.Fatalf("%v: exit code %d", args, code)
+		}
+	}
+}
+
+func TestRunCheckCommandModule(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	caFile := writeCAFile(t, ca)
+	leaf := newTestCert(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "www.example.com"},
+		DNSNames: []string{"www.example.com"},
+		NotAfter: time.Now().Add(60 * 24 * time.Hour),
+	}, ca)
+	port := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{leaf.TLSCertificate()}})
+	target := fmt.Sprintf("127.0.0.1:%d/www.example.com", port)
+	expired := newTestCert(t, &x509.Certificate{
+		DNSNames:  []string{"www.example.com"},
+		NotBefore: time.Now().Add(-48 * time.Hour),
+		NotAfter:  time.Now().Add(-24 * time.Hour),
+	}, ca)
+	expiredPort := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{expired.TLSCertificate()}})
+	configFile := filepath.Join(t.TempDir(), "config.yml")
+	config := "warningDays: 90\nmodules:\n  insecure:\n    skipVerify: true\n    defaultCert: noSNI\n"
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer Exp.SetExpiryDefaults(ExpiryThresholds{})
+
+	run := func(args ...string) (int, *CheckResult) {
+		var stdout, stderr bytes.Buffer
+		code := RunCheckCommand(append([]string{"-o", "json"}, args...), &stdout, &stderr)
+		var results []*CheckResult
+		if err := json.Unmarshal(stdout.Bytes(), &results); err != nil || len(results) != 1 {
+			t.Fatalf("%v: exit code %d, unexpected output: %s %s", args, code, stdout.String(), stderr.String())
+		}
+		return code, results[0]
+	}
+
+	// the expired certificate failed to verify is still expired.
+	code, r := run("--ca-file", caFile, "--retry", "1", fmt.Sprintf("127.0.0.1:%d/www.example.com", expiredPort))
+	if code != 1 || r.Success || r.Status != CertStatusExpired || r.Verification != VerificationFailed || r.ErrorClass != ErrorClassExpired {
+		t.Fatalf("exit code %d, unexpected result %+v", code, r)
+	}
+
+	// the module skips verify and checks the default certificate, warningDays comes from the config.
+	code, r = run("--config", configFile, "--module", "insecure", "--legacy-ca-file", caFile, target)
+	if code != 1 || !r.Success || r.Status != CertStatusWarning || r.Verification != VerificationSkipped ||
+		r.DefaultCert == nil || r.LegacyVerification != VerificationVerified {
+		t.Fatalf("exit code %d, unexpected result %+v", code, r)
+	}
+	// the flags override the module, even to false.
+	code, r = run("--config", configFile, "--module", "insecure", "--skip-verify=false", "--ca-file", caFile, "--warning-days", "30", target)
+	if code != 0 || r.Status != CertStatusOK || r.Verification != VerificationVerified || r.DefaultCert == nil {
+		t.Fatalf("exit code %d, unexpected result %+v", code, r)
+	}
+
+	var stdout, stderr bytes.Buffer
+	for _, args := range [][]string{{"--config", configFile, "--module", "missing", target}, {"--default-cert", "sni", target}, {"--default-cert", "nosni", target}, {"--default-cert", "bogus-sni", target}} {
+		if code = RunCheckCommand(args, &stdout, &stderr); code != 2 {
+			t.Fatalf("%v: exit code %d", args, code)
+		}
+	}
+}
@@ -2,16 +2,54 @@ package common
 
 import (
 	"fmt"
+	"time"
 	"tlsprobe/autodiscover"
+	"tlsprobe/notifier"
 )
 
 type HostScannerConfig struct {
+	// Host is the host to scan, a CIDR like 10.20.0.0/22 is also allowed.
 	Host       string          `yaml:"host"`
 	TLSOptions TLSCheckOptions `yaml:"TLSOptions"`
+	// Module is the name of the module which fills the unset TLSOptions.
+	Module string `yaml:"module"`
+	// Ports is the port spec to scan like "web,443,9000-9100", default is 10-65535.
+	Ports string `yaml:"ports"`
+	// ExcludePorts is the port spec which should not be scanned.
+	ExcludePorts string `yaml:"excludePorts"`
+	// RescanInterval is the seconds between full rescans, 0 means only scan once.
+	RescanInterval uint `yaml:"rescanInterval"`
+	// RecheckInterval is the seconds between rechecks of the known ports, 0 means never recheck.
+	RecheckInterval uint `yaml:"recheckInterval"`
+	// RetireAfter is the consecutive failed probes before a known port is retired, default is 3.
+	RetireAfter uint `yaml:"retireAfter"`
+	// CIDR is the range to scan, every host in it is scanned like a single HostScanner.
+	CIDR string `yaml:"cidr"`
+	// MaxConnections caps the concurrent connections of the CIDR scan, 0 means no limit.
+	MaxConnections uint `yaml:"maxConnections"`
+	// RateLimit caps the new connections per second of the CIDR scan, 0 means no limit.
+	RateLimit uint `yaml:"rateLimit"`
+	// Labels are added to every metric of the HostScanner and its TLSCheckers.
+	Labels Labels `yaml:"labels"`
+}
+
+func (h *HostScannerConfig) GetRescanInterval() time.Duration {
+	return time.Duration(h.RescanInterval) * time.Second
+}
+
+func (h *HostScannerConfig) GetRecheckInterval() time.Duration {
+	return time.Duration(h.RecheckInterval) * time.Second
+}
+
+func (h *HostScannerConfig) GetRetireAfter() uint {
+	if h.RetireAfter == 0 {
+		return 3
+	}
+	return h.RetireAfter
 }
 
 func (h *HostScannerConfig) Key() string {
-	return fmt.Sprintf("HostScanner Host: %s, domain: %s", h.Host, h.TLSOptions.Domain)
+	return fmt.Sprintf("HostScanner Host: %s, domain: %s", h.Target(), h.TLSOptions.Domain)
 }
 
 type Config struct {
@@ -21,4 +59,34 @@ type Config struct {
 	MaxConnections        uint                  `yaml:"maxConnections"`
 	MaxCollectConnections uint                  `yaml:"maxCollectConnections"`
 	ListenAddr            string                `yaml:"listenAddr"`
+	// StateFile is the JSON file which saves the discovered ports, records and probe results across restarts.
+	StateFile string `yaml:"stateFile"`
+	// StateSaveInterval is the seconds between saving the state file, default is 60.
+	StateSaveInterval uint `yaml:"stateSaveInterval"`
+	// Modules are the named TLSCheckOptions referenced by TLSCheckers, hostScanners,
+	// autoDiscovers and the /probe handler.
+	Modules map[string]TLSCheckOptions `yaml:"modules"`
+	// LegacyMetrics keeps exporting the series of metric schema 1 besides the current schema, default is true.
+	// It is only kept for one release to migrate the dashboards and the alerts.
+	LegacyMetrics bool `yaml:"legacyMetrics"`
+	// ExpiryThresholds are the global days before NotAfter when a certificate turns warning and critical,
+	// default are 30 and 7 days.
+	ExpiryThresholds `yaml:",inline"`
+	// Notifiers sends the expiry and the probe failure alerts without Alertmanager.
+	Notifiers notifier.Config `yaml:"notifiers"`
+	// Report sends a weekly email of the certificates which are expiring soon.
+	Report ReportConfig `yaml:"report"`
+	// Ownership are the rules adding labels to the discovered records by domain.
+	Ownership []OwnershipRule `yaml:"ownership"`
+	// Silences mute the matched TLSCheckers, more silences can be created by the /api/v1/silences API.
+	Silences []Silence `yaml:"silences"`
+	// AdminToken is the bearer token of the admin API, the admin API is disabled when it is empty.
+	AdminToken string `yaml:"adminToken"`
+}
+
+func (c *Config) GetStateSaveInterval() time.Duration {
+	if c.StateSaveInterval == 0 {
+		return time.Minute
+	}
+	return time.Duration(c.StateSaveInterval) * time.Second
 }
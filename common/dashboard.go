@@ -0,0 +1,20 @@
+package common
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// DashboardHandler serves the web dashboard of the certificate status under /dashboard/,
+// it is backed by the /api/v1/checkers API.
+func DashboardHandler() http.Handler {
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/dashboard/", http.FileServer(http.FS(files)))
+}
@@ -0,0 +1,169 @@
+"use strict";
+
+// the dashboard is served under /dashboard/, the API is relative to the root.
+const api = "../api/v1/checkers";
+let checkers = [];
+
+function el(tag, text, className) {
+  const e = document.createElement(tag);
+  if (text !== undefined && text !== null) {
+    e.textContent = text;
+  }
+  if (className) {
+    e.className = className;
+  }
+  return e;
+}
+
+function row(cells) {
+  const tr = el("tr");
+  for (const c of cells) {
+    const td = el("td");
+    if (c instanceof Node) {
+      td.appendChild(c);
+    } else {
+      td.textContent = c === undefined || c === null ? "" : c;
+    }
+    tr.appendChild(td);
+  }
+  return tr;
+}
+
+function statusBadge(status) {
+  return el("span", status, "status status-" + status);
+}
+
+function formatTime(t) {
+  if (!t || t.startsWith("0001-")) {
+    return "";
+  }
+  return new Date(t).toLocaleString();
+}
+
+function team(c) {
+  return (c.labels && c.labels.team) || "";
+}
+
+function compareExpiry(a, b) {
+  // the endpoints without a certificate are listed last.
+  const da = a.expiresInDays === null ? Infinity : a.expiresInDays;
+  const db = b.expiresInDays === null ? Infinity : b.expiresInDays;
+  if (da !== db) {
+    return da - db;
+  }
+  return a.key < b.key ? -1 : 1;
+}
+
+function renderList() {
+  const domain = document.getElementById("filter-domain").value.trim().toLowerCase();
+  const teamFilter = document.getElementById("filter-team").value;
+  const status = document.getElementById("filter-status").value;
+  const tbody = document.getElementById("endpoints");
+  tbody.replaceChildren();
+  const shown = checkers.filter(c =>
+    (!domain || c.domain.toLowerCase().includes(domain)) &&
+    (!teamFilter || team(c) === teamFilter) &&
+    (!status || c.status === status));
+  for (const c of shown) {
+    const tr = row([
+      statusBadge(c.status),
+      c.expiresInDays,
+      c.domain,
+      c.host + ":" + c.port,
+      team(c),
+      c.owner,
+      c.certificate ? formatTime(c.certificate.notAfter) : "",
+      c.lastResult ? formatTime(c.lastResult.checkedAt) : "never",
+    ]);
+    tr.addEventListener("click", () => { location.hash = encodeURIComponent(c.key); });
+    tbody.appendChild(tr);
+  }
+  document.getElementById("count").textContent = shown.length + " / " + checkers.length + " endpoints";
+}
+
+function renderTeams() {
+  const select = document.getElementById("filter-team");
+  const selected = select.value;
+  const teams = [...new Set(checkers.map(team).filter(t => t))].sort();
+  select.replaceChildren(el("option", "all teams"));
+  select.firstChild.value = "";
+  for (const t of teams) {
+    select.appendChild(el("option", t));
+  }
+  select.value = selected;
+}
+
+async function loadList() {
+  const resp = await fetch(api);
+  checkers = (await resp.json()).sort(compareExpiry);
+  document.getElementById("updated").textContent = "updated at " + new Date().toLocaleString();
+  renderTeams();
+  renderList();
+}
+
+async function showDetail(key) {
+  const resp = await fetch(api + "?key=" + encodeURIComponent(key));
+  const found = await resp.json();
+  if (found.length === 0) {
+    location.hash = "";
+    return;
+  }
+  const c = found[0];
+  document.getElementById("detail-title").textContent = c.host + ":" + c.port + " / " + c.domain;
+  const summary = document.getElementById("detail-summary");
+  summary.replaceChildren();
+  const verify = c.skipVerify ? "skipped" : (c.lastResult && c.lastResult.success ? "verified" : "failed");
+  const fields = [
+    ["status", statusBadge(c.status)],
+    ["days left", c.expiresInDays],
+    ["verification", verify],
+    ["last error", c.lastResult ? c.lastResult.error : ""],
+    ["error class", c.lastResult ? c.lastResult.errorClass : ""],
+    ["owner", c.owner],
+    ["labels", c.labels ? Object.entries(c.labels).map(([k, v]) => k + "=" + v).join(", ") : ""],
+    ["origin", c.origin ? c.origin.kind + ": " + c.origin.describe : ""],
+  ];
+  for (const [name, value] of fields) {
+    summary.appendChild(el("dt", name));
+    const dd = el("dd");
+    if (value instanceof Node) {
+      dd.appendChild(value);
+    } else {
+      dd.textContent = value === undefined || value === null ? "" : value;
+    }
+    summary.appendChild(dd);
+  }
+  const chain = document.getElementById("detail-chain");
+  chain.replaceChildren();
+  (c.chain || []).forEach((cert, i) => {
+    chain.appendChild(row([i, cert.subject, cert.issuer, formatTime(cert.notBefore), formatTime(cert.notAfter), cert.fingerprint]));
+  });
+  const history = document.getElementById("detail-history");
+  history.replaceChildren();
+  for (const r of (c.history || []).slice().reverse()) {
+    history.appendChild(row([formatTime(r.checkedAt), r.success ? "success" : "failed",
+      r.durationSeconds.toFixed(3) + "s", r.error, r.silence]));
+  }
+  document.getElementById("list").hidden = true;
+  document.getElementById("detail").hidden = false;
+}
+
+function route() {
+  const key = decodeURIComponent(location.hash.slice(1));
+  if (key) {
+    showDetail(key);
+    return;
+  }
+  document.getElementById("detail").hidden = true;
+  document.getElementById("list").hidden = false;
+  loadList();
+}
+
+document.getElementById("filters").addEventListener("input", renderList);
+document.getElementById("filters").addEventListener("submit", e => e.preventDefault());
+document.getElementById("back").addEventListener("click", e => {
+  e.preventDefault();
+  location.hash = "";
+});
+window.addEventListener("hashchange", route);
+route();
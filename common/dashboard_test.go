@@ -0,0 +1,18 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDashboardHandler(t *testing.T) {
+	for path, content := range map[string]string{"/dashboard/": "<title>TLSProbe</title>", "/dashboard/app.js": "api/v1/checkers"} {
+		rec := httptest.NewRecorder()
+		DashboardHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), content) {
+			t.Fatalf("GET %s should return the embedded file, but now is: %d", path, rec.Code)
+		}
+	}
+}
@@ -4,5 +4,6 @@ func DefaultConfig() Config {
 	return Config{
 		MaxCollectConnections: 1500,
 		MaxConnections:        1500,
+		LegacyMetrics:         true,
 	}
 }
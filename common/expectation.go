@@ -0,0 +1,137 @@
+package common
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	ExpectationRuleSPKIPin       = "spkiPin"
+	ExpectationRuleIssuer        = "issuer"
+	ExpectationRuleCAFingerprint = "caFingerprint"
+	ExpectationRuleSAN           = "san"
+)
+
+// TLSExpectations is what a TLSChecker expects the server to present,
+// an empty field means the rule is not checked.
+type TLSExpectations struct {
+	// SPKIPins are base64 encoded sha256 of the leaf SubjectPublicKeyInfo, like "sha256/AbCd...=".
+	SPKIPins []string `yaml:"spkiPins"`
+	// Issuers are the allowed issuer DNs of the leaf, like "CN=R3,O=Let's Encrypt,C=US".
+	Issuers []string `yaml:"issuers"`
+	// CAFingerprints are the allowed sha256 fingerprints of any CA certificate of the verified chains, so a root which
+	// is only in the CAFile can be pinned too. The certificates sent by the server are only used with skipVerify.
+	CAFingerprints []string `yaml:"caFingerprints"`
+	// SANs must all be present in the leaf's DNS names, IP addresses, emails or URIs.
+	SANs []string `yaml:"sans"`
+}
+
+func (e *TLSExpectations) Empty() bool {
+	return len(e.SPKIPins) == 0 && len(e.Issuers) == 0 && len(e.CAFingerprints) == 0 && len(e.SANs) == 0
+}
+
+// Evaluate checks the peer certificates and the verified chains and returns the failed rules.
+// The CA certificates sent by the server are unverified, anyone can send the pinned CA, so they are only matched
+// when the verification is skipped, a checker whose verification failed never matches caFingerprints.
+func (e *TLSExpectations) Evaluate(certs []*x509.Certificate, chains [][]*x509.Certificate, skipVerify bool) []string {
+	failed := make([]string, 0)
+	if len(certs) == 0 {
+		if !e.Empty() {
+			failed = append(failed, "noCertificate")
+		}
+		return failed
+	}
+	leaf := certs[0]
+	if len(e.SPKIPins) > 0 && !containsString(normalizePins(e.SPKIPins), SPKIPin(leaf)) {
+		failed = append(failed, ExpectationRuleSPKIPin)
+	}
+	if len(e.Issuers) > 0 && !containsString(e.Issuers, leaf.Issuer.String()) {
+		failed = append(failed, ExpectationRuleIssuer)
+	}
+	if len(e.CAFingerprints) > 0 {
+		fingerprints := normalizeFingerprints(e.CAFingerprints)
+		matched := false
+		var cas []*x509.Certificate
+		for _, chain := range chains {
+			if len(chain) > 1 {
+				cas = append(cas, chain[1:]...)
+			}
+		}
+		if len(chains) == 0 && skipVerify {
+			cas = certs[1:]
+		}
+		for _, c := range cas {
+			if containsString(fingerprints, Fingerprint(c)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			failed = append(failed, ExpectationRuleCAFingerprint)
+		}
+	}
+	if len(e.SANs) > 0 {
+		sans := CertSANs(leaf)
+		for _, san := range e.SANs {
+			if !containsString(sans, san) {
+				failed = append(failed, ExpectationRuleSAN)
+				break
+			}
+		}
+	}
+	return failed
+}
+
+// SPKIPin returns the base64 encoded sha256 of the certificate's SubjectPublicKeyInfo.
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Fingerprint returns the hex encoded sha256 of the certificate.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// CertSANs returns all subject alternative names of the certificate as strings.
+func CertSANs(cert *x509.Certificate) []string {
+	sans := make([]string, 0, len(cert.DNSNames))
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
+
+func normalizePins(pins []string) []string {
+	normalized := make([]string, len(pins))
+	for i, pin := range pins {
+		normalized[i] = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+	}
+	return normalized
+}
+
+func normalizeFingerprints(fingerprints []string) []string {
+	normalized := make([]string, len(fingerprints))
+	for i, f := range fingerprints {
+		normalized[i] = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(f), ":", ""))
+	}
+	return normalized
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/prometheus/client_golang/prometheus"
+	"reflect"
+	"testing"
+)
+
+func TestTLSExpectationsEvaluate(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	leaf := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com", "example.com"}}, ca)
+	other := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "other ca"}}, nil)
+	certs := []*x509.Certificate{leaf.cert, ca.cert}
+
+	cases := []struct {
+		name   string
+		expect TLSExpectations
+		failed []string
+	}{
+		{"empty", TLSExpectations{}, []string{}},
+		{
+			"all passed",
+			TLSExpectations{
+				SPKIPins:       []string{"sha256/" + SPKIPin(leaf.cert)},
+				Issuers:        []string{"CN=test ca"},
+				CAFingerprints: []string{Fingerprint(ca.cert)},
+				SANs:           []string{"example.com"},
+			},
+			[]string{},
+		},
+		{
+			"all failed",
+			TLSExpectations{
+				SPKIPins:       []string{SPKIPin(ca.cert)},
+				Issuers:        []string{"CN=other ca"},
+				CAFingerprints: []string{Fingerprint(other.cert)},
+				SANs:           []string{"example.com", "api.example.com"},
+			},
+			[]string{ExpectationRuleSPKIPin, ExpectationRuleIssuer, ExpectationRuleCAFingerprint, ExpectationRuleSAN},
+		},
+	}
+	for _, c := range cases {
+		failed := c.expect.Evaluate(certs, nil, true)
+		if !reflect.DeepEqual(failed, c.failed) {
+			t.Fatalf("%s: failed rules should be %v, but now is: %v", c.name, c.failed, failed)
+		}
+	}
+
+	// the CA sent by the server is not trusted once the certificates are verified or failed to verify.
+	expect := TLSExpectations{CAFingerprints: []string{Fingerprint(other.cert)}}
+	sent := []*x509.Certificate{leaf.cert, other.cert}
+	chains := [][]*x509.Certificate{{leaf.cert, ca.cert}}
+	if failed := expect.Evaluate(sent, chains, false); !reflect.DeepEqual(failed, []string{ExpectationRuleCAFingerprint}) {
+		t.Fatalf("the sent CA should not match the verified chains, but now is: %v", failed)
+	}
+	if failed := expect.Evaluate(sent, nil, false); !reflect.DeepEqual(failed, []string{ExpectationRuleCAFingerprint}) {
+		t.Fatalf("the sent CA should not match without verified chains, but now is: %v", failed)
+	}
+	expect = TLSExpectations{CAFingerprints: []string{Fingerprint(ca.cert)}}
+	if failed := expect.Evaluate(sent, chains, false); len(failed) != 0 {
+		t.Fatalf("the root of the verified chains should match, but now is: %v", failed)
+	}
+}
+
+func TestTLSCheckerExpectationsRootFingerprint(t *testing.T) {
+	root := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test root"}}, nil)
+	intermediate := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test intermediate"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, root)
+	leaf := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com"}}, intermediate)
+	other := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "other root"}}, nil)
+	// the server sends the intermediate and the other root, the root is only in the CAFile.
+	certificate := leaf.TLSCertificate()
+	certificate.Certificate = append(certificate.Certificate, intermediate.cert.Raw, other.cert.Raw)
+	port := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{certificate}})
+	caFile := writeCAFile(t, root)
+
+	cases := []struct {
+		name         string
+		options      TLSCheckOptions
+		fingerprints []string
+		success      float64
+	}{
+		{"root", TLSCheckOptions{CAFile: caFile}, []string{Fingerprint(root.cert)}, 1},
+		{"intermediate", TLSCheckOptions{CAFile: caFile}, []string{Fingerprint(intermediate.cert)}, 1},
+		{"sent only", TLSCheckOptions{CAFile: caFile}, []string{Fingerprint(other.cert)}, 0},
+		{"verify failed", TLSCheckOptions{CAFile: writeCAFile(t, other)}, []string{Fingerprint(other.cert)}, 0},
+		{"skipVerify", TLSCheckOptions{InsecureSkipVerify: true}, []string{Fingerprint(other.cert)}, 1},
+	}
+	for _, c := range cases {
+		c.options.Domain = "www.example.com"
+		checker := NewTLSChecker(nil, "127.0.0.1", port, c.options)
+		checker.Expect = TLSExpectations{CAFingerprints: c.fingerprints}
+		registry := prometheus.NewPedanticRegistry()
+		if err := registry.Register(checkersCollector{checker}); err != nil {
+			t.Fatal(err)
+		}
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		success := -1.0
+		for _, f := range families {
+			if f.GetName() == "tlsprobe_expectation_success" && len(f.GetMetric()) == 1 {
+				success = f.GetMetric()[0].GetGauge().GetValue()
+			}
+		}
+		if success != c.success {
+			t.Fatalf("%s: expectation success should be %v, but now is: %v", c.name, c.success, success)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"crypto/tls"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+const (
+	CertStatusOK       = "ok"
+	CertStatusWarning  = "warning"
+	CertStatusCritical = "critical"
+	CertStatusExpired  = "expired"
+	CertStatusError    = "error"
+)
+
+// CertStatuses are all values of tlsprobe_cert_status, every one is exported so the series never disappear.
+var CertStatuses = []string{CertStatusOK, CertStatusWarning, CertStatusCritical, CertStatusExpired, CertStatusError}
+
+const (
+	DefaultWarningDays  = 30
+	DefaultCriticalDays = 7
+)
+
+// ExpiryThresholds are the days before NotAfter when a certificate turns warning and critical.
+type ExpiryThresholds struct {
+	WarningDays  uint `yaml:"warningDays"`
+	CriticalDays uint `yaml:"criticalDays"`
+}
+
+// Merge returns thresholds whose zero fields are filled from base.
+func (e ExpiryThresholds) Merge(base ExpiryThresholds) ExpiryThresholds {
+	if e.WarningDays == 0 {
+		e.WarningDays = base.WarningDays
+	}
+	if e.CriticalDays == 0 {
+		e.CriticalDays = base.CriticalDays
+	}
+	return e
+}
+
+// Status returns the status of a certificate which expires in expiresIn.
+func (e ExpiryThresholds) Status(expiresIn time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case expiresIn <= 0:
+		return CertStatusExpired
+	case expiresIn < time.Duration(e.CriticalDays)*day:
+		return CertStatusCritical
+	case expiresIn < time.Duration(e.WarningDays)*day:
+		return CertStatusWarning
+	}
+	return CertStatusOK
+}
+
+// ExpiryThresholds returns the thresholds of the TLSCheckOptions, the unset fields come from the global thresholds.
+func (o *TLSCheckOptions) ExpiryThresholds() ExpiryThresholds {
+	thresholds := ExpiryThresholds{WarningDays: o.WarningDays, CriticalDays: o.CriticalDays}
+	return thresholds.Merge(Exp.ExpiryDefaults())
+}
+
+// collectExpiry exports the seconds before NotAfter of the leaf certificate and its status.
+// The expiry is exported whenever the server sent a leaf, even if it failed to verify.
+// The status is error when the check failed, unless the leaf is expired.
+func (t *TLSChecker) collectExpiry(ch chan<- prometheus.Metric, stat *tls.ConnectionState, err error) {
+	status := CertStatusError
+	if stat != nil && len(stat.PeerCertificates) > 0 {
+		expiresIn := time.Until(stat.PeerCertificates[0].NotAfter)
+		sendLabeledGauge(ch, descCertExpiresIn, expiresIn.Seconds(), t.Labels, t.endpointLabelValues()...)
+		if Exp.LegacyMetrics() {
+			sendGauge(ch, descV1CertExpiresIn, expiresIn.Seconds(), t.endpointLabelValues()...)
+		}
+		if s := t.ExpiryThresholds().Status(expiresIn); err == nil || s == CertStatusExpired {
+			status = s
+		}
+	}
+	for _, s := range CertStatuses {
+		var value float64 = 0
+		if s == status {
+			value = 1
+		}
+		sendLabeledGauge(ch, descCertStatus, value, t.Labels, append(t.endpointLabelValues(), s)...)
+	}
+}
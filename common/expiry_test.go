@@ -0,0 +1,100 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/prometheus/client_golang/prometheus"
+	"testing"
+	"time"
+)
+
+func TestExpiryThresholdsStatus(t *testing.T) {
+	const day = 24 * time.Hour
+	thresholds := ExpiryThresholds{WarningDays: 30, CriticalDays: 7}
+	cases := []struct {
+		expiresIn time.Duration
+		status    string
+	}{
+		{60 * day, CertStatusOK},
+		{30 * day, CertStatusOK},
+		{29 * day, CertStatusWarning},
+		{6 * day, CertStatusCritical},
+		{0, CertStatusExpired},
+		{-day, CertStatusExpired},
+	}
+	for _, c := range cases {
+		if status := thresholds.Status(c.expiresIn); status != c.status {
+			t.Fatalf("%s: status should be %s, but now is: %s", c.expiresIn, c.status, status)
+		}
+	}
+}
+
+func TestTLSCheckOptionsExpiryThresholds(t *testing.T) {
+	defer Exp.SetExpiryDefaults(ExpiryThresholds{})
+	Exp.SetExpiryDefaults(ExpiryThresholds{WarningDays: 45})
+	options := TLSCheckOptions{CriticalDays: 14}
+	expected := ExpiryThresholds{WarningDays: 45, CriticalDays: 14}
+	if thresholds := options.ExpiryThresholds(); thresholds != expected {
+		t.Fatalf("thresholds should be %+v, but now is: %+v", expected, thresholds)
+	}
+
+	Exp.SetExpiryDefaults(ExpiryThresholds{})
+	expected = ExpiryThresholds{WarningDays: DefaultWarningDays, CriticalDays: 14}
+	if thresholds := options.ExpiryThresholds(); thresholds != expected {
+		t.Fatalf("thresholds should be %+v, but now is: %+v", expected, thresholds)
+	}
+}
+
+func TestCollectExpiryExpiredVerified(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	expired := newTestCert(t, &x509.Certificate{
+		DNSNames:  []string{"www.example.com"},
+		NotBefore: time.Now().Add(-48 * time.Hour),
+		NotAfter:  time.Now().Add(-24 * time.Hour),
+	}, ca)
+	port := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{expired.TLSCertificate()}})
+	checker := NewTLSChecker(nil, "127.0.0.1", port, TLSCheckOptions{Domain: "www.example.com", CAFile: writeCAFile(t, ca)})
+
+	stat, err := checker.Check()
+	if err == nil || ErrorClass(err) != ErrorClassExpired {
+		t.Fatalf("check should fail with an expired error, but now is: %v", err)
+	}
+	if stat == nil || len(stat.PeerCertificates) == 0 {
+		t.Fatal("the handshake state should be returned with the verify error")
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(checkersCollector{checker}); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status string
+	var expiresIn *float64
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			switch f.GetName() {
+			case "tlsprobe_cert_status":
+				if m.GetGauge().GetValue() == 1 {
+					for _, l := range m.GetLabel() {
+						if l.GetName() == "status" {
+							status = l.GetValue()
+						}
+					}
+				}
+			case "tlsprobe_cert_expires_in_seconds":
+				v := m.GetGauge().GetValue()
+				expiresIn = &v
+			}
+		}
+	}
+	if status != CertStatusExpired {
+		t.Fatalf("status should be expired, but now is: %q", status)
+	}
+	if expiresIn == nil || *expiresIn >= 0 {
+		t.Fatalf("expires in seconds should be negative, but now is: %v", expiresIn)
+	}
+}
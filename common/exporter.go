@@ -2,9 +2,12 @@ package common
 
 import (
 	"context"
+	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 	"tlsprobe/autodiscover"
 	"tlsprobe/common/creator"
@@ -16,11 +19,31 @@ type Exporter struct {
 	HostScanners          map[string]*HostScanner
 	TLSCheckers           map[string]*TLSChecker
 	AutoDiscover          map[string]autodiscover.AutoDiscover
+	Inventory             *Inventory
+	Notifier              *NotifyManager
+	Reporter              *Reporter
+	Silences              *SilenceManager
+	Admin                 *AdminManager
 	MaxCollectConnections uint
 	HostScannerRWMutex    *sync.RWMutex
 	CheckerRWMutex        *sync.RWMutex
 	AutoDiscoverRWMutex   *sync.RWMutex
 	waitPool              *WaitPool
+	modules               map[string]TLSCheckOptions
+	modulesRWMutex        *sync.RWMutex
+	// legacyMetrics is 1 when the series of schema 1 are exported too.
+	legacyMetrics int32
+	// expiryDefaults are the global expiry thresholds.
+	expiryDefaults ExpiryThresholds
+	expiryRWMutex  *sync.RWMutex
+	// ownership are the rules adding labels to the discovered records.
+	ownership        []OwnershipRule
+	ownershipRWMutex *sync.RWMutex
+	// results are the last probeHistorySize probe results of the TLSCheckers, the key is TLSChecker.Key().
+	results        map[string][]*ProbeResult
+	resultsRWMutex *sync.RWMutex
+	// described is 1 after the descriptors are described to a registry.
+	described int32
 }
 
 type UpdateAutoDiscoverType func(ctx context.Context, cfg *autodiscover.Config, creator creator.Creator)
@@ -34,9 +57,21 @@ func NewExporter() *Exporter {
 		HostScanners:        make(map[string]*HostScanner),
 		TLSCheckers:         make(map[string]*TLSChecker),
 		AutoDiscover:        make(map[string]autodiscover.AutoDiscover),
+		Inventory:           NewInventory(),
+		Notifier:            NewNotifyManager(),
+		Reporter:            NewReporter(),
+		Silences:            NewSilenceManager(),
+		Admin:               NewAdminManager(),
 		CheckerRWMutex:      new(sync.RWMutex),
 		HostScannerRWMutex:  new(sync.RWMutex),
 		AutoDiscoverRWMutex: new(sync.RWMutex),
+		modules:             make(map[string]TLSCheckOptions),
+		modulesRWMutex:      new(sync.RWMutex),
+		expiryDefaults:      ExpiryThresholds{WarningDays: DefaultWarningDays, CriticalDays: DefaultCriticalDays},
+		expiryRWMutex:       new(sync.RWMutex),
+		ownershipRWMutex:    new(sync.RWMutex),
+		results:             make(map[string][]*ProbeResult),
+		resultsRWMutex:      new(sync.RWMutex),
 	}
 	// set default connections.
 	e.SetMaxConnections(100)
@@ -44,6 +79,39 @@ func NewExporter() *Exporter {
 }
 
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	atomic.StoreInt32(&e.described, 1)
+	for _, d := range checkerDescs {
+		ch <- d
+	}
+	for _, d := range exporterDescs {
+		ch <- d
+	}
+}
+
+// SetLegacyMetrics enables or disables exporting the series of metric schema 1.
+func (e *Exporter) SetLegacyMetrics(enabled bool) {
+	var value int32 = 0
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&e.legacyMetrics, value)
+}
+
+func (e *Exporter) LegacyMetrics() bool {
+	return atomic.LoadInt32(&e.legacyMetrics) == 1
+}
+
+// SetExpiryDefaults sets the global expiry thresholds, the unset fields use DefaultWarningDays and DefaultCriticalDays.
+func (e *Exporter) SetExpiryDefaults(thresholds ExpiryThresholds) {
+	e.expiryRWMutex.Lock()
+	defer e.expiryRWMutex.Unlock()
+	e.expiryDefaults = thresholds.Merge(ExpiryThresholds{WarningDays: DefaultWarningDays, CriticalDays: DefaultCriticalDays})
+}
+
+func (e *Exporter) ExpiryDefaults() ExpiryThresholds {
+	e.expiryRWMutex.RLock()
+	defer e.expiryRWMutex.RUnlock()
+	return e.expiryDefaults
 }
 
 func (e *Exporter) SetMaxConnections(limit uint) {
@@ -53,6 +121,113 @@ func (e *Exporter) SetMaxConnections(limit uint) {
 	e.waitPool = NewWaitPool(limit)
 }
 
+// SetModules replaces all modules, and returns the names of the added, changed or removed modules.
+func (e *Exporter) SetModules(modules map[string]TLSCheckOptions) map[string]struct{} {
+	e.modulesRWMutex.Lock()
+	defer e.modulesRWMutex.Unlock()
+	changed := make(map[string]struct{})
+	for name, m := range e.modules {
+		if newModule, exists := modules[name]; !exists || newModule != m {
+			changed[name] = struct{}{}
+		}
+	}
+	for name := range modules {
+		if _, exists := e.modules[name]; !exists {
+			changed[name] = struct{}{}
+		}
+	}
+	e.modules = make(map[string]TLSCheckOptions, len(modules))
+	for name, m := range modules {
+		e.modules[name] = m
+	}
+	return changed
+}
+
+// ResolveOptions fills the zero fields of options from the named module.
+func (e *Exporter) ResolveOptions(module string, options TLSCheckOptions) TLSCheckOptions {
+	if module == "" {
+		return options
+	}
+	m, exists := e.GetModule(module)
+	if !exists {
+		log.Warn().Msgf("module %s not exists, use the options as is", module)
+		return options
+	}
+	return MergeTLSCheckOptions(options, m)
+}
+
+// ResolveTLSChecker keeps the raw options of the TLSChecker and fills them from its module.
+func (e *Exporter) ResolveTLSChecker(t *TLSChecker) {
+	t.RawOptions = t.TLSCheckOptions
+	t.TLSCheckOptions = e.ResolveOptions(t.Module, t.RawOptions)
+}
+
+// ReloadModuleDependents reloads the hostScanners and the TLSCheckers which reference the changed modules,
+// including the hostScanners created by autoDiscover and the TLSCheckers created by the admin API.
+// The TLSCheckers of the found ports are recreated with their hostScanner.
+func (e *Exporter) ReloadModuleDependents(changed map[string]struct{}) {
+	if len(changed) == 0 {
+		return
+	}
+	dependents := make([]*HostScanner, 0)
+	e.HostScannerRWMutex.RLock()
+	for _, hs := range e.HostScanners {
+		if _, exists := changed[hs.Config.Module]; exists {
+			dependents = append(dependents, hs)
+		}
+	}
+	e.HostScannerRWMutex.RUnlock()
+	for _, hs := range dependents {
+		rawConfig := hs.RawConfig
+		e.UpdateHostScannerConfig(hs.parentCtx, &rawConfig, hs.Creator)
+	}
+
+	checkers := make([]*TLSChecker, 0)
+	e.CheckerRWMutex.RLock()
+	for _, t := range e.TLSCheckers {
+		if _, exists := changed[t.Module]; exists && t.Module != "" {
+			checkers = append(checkers, t)
+		}
+	}
+	e.CheckerRWMutex.RUnlock()
+	for _, t := range checkers {
+		e.reloadTLSChecker(t)
+	}
+}
+
+// reloadTLSChecker resolves the raw options of the TLSChecker again and replaces it,
+// the key changes when the module sets the domain.
+func (e *Exporter) reloadTLSChecker(t *TLSChecker) {
+	c := &TLSChecker{
+		Host:            t.Host,
+		Port:            t.Port,
+		TLSCheckOptions: t.RawOptions,
+		Expect:          t.Expect,
+		Module:          t.Module,
+		Labels:          t.Labels,
+	}
+	e.ResolveTLSChecker(c)
+	if t.Creator == AdminCreator {
+		// the same as AdminManager.AddTLSChecker.
+		c.SetDefaultOption()
+	}
+	if c.Key() != t.Key() {
+		e.RemoveTLSChecker(t.Key())
+		if t.Creator == AdminCreator {
+			e.Admin.rename(AdminObjectTLSChecker, t.Key(), c.Key())
+		}
+	}
+	log.Info().Msgf("reload tls checker %s of module %s", c.Key(), c.Module)
+	e.UpdateTLSChecker(context.Background(), c, t.Creator)
+}
+
+func (e *Exporter) GetModule(name string) (TLSCheckOptions, bool) {
+	e.modulesRWMutex.RLock()
+	defer e.modulesRWMutex.RUnlock()
+	m, exists := e.modules[name]
+	return m, exists
+}
+
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	// hostScanner
 	startTime := time.Now()
@@ -67,12 +242,129 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	e.CheckerRWMutex.RLock()
 	defer e.CheckerRWMutex.RUnlock()
 	for _, t := range e.TLSCheckers {
-		wa.Run(t.CollectTLSStatus, ch)
+		wa.Run(e.collectTLSChecker, t, ch)
 	}
 	wa.Wait()
+	e.Inventory.Prune(e.TLSCheckers, time.Now())
+	e.Inventory.Collect(ch)
+	e.collectSelfMetrics(ch)
+	sendGauge(ch, descSchemaInfo, 1)
+	sendGauge(ch, descCollectDuration, time.Since(startTime).Seconds())
 	log.Debug().Msgf("collect total time: %s", time.Since(startTime))
 }
 
+func (e *Exporter) collectTLSChecker(t *TLSChecker, ch chan<- prometheus.Metric) {
+	startTime := time.Now()
+	silence := e.Silences.Match(t, startTime)
+	if silence == nil {
+		stat, err := t.CollectTLSStatus(ch)
+		e.Inventory.Observe(t, stat, err)
+		e.Notifier.Observe(t, stat, err)
+		e.setResult(t, startTime, err, nil)
+		return
+	}
+	sendLabeledGauge(ch, descSilenced, 1, t.Labels, append(t.endpointLabelValues(), silence.ID, fmt.Sprintf("%t", silence.Skip))...)
+	// the silenced TLSChecker does not notify, the firing alerts are resolved.
+	e.Notifier.Remove(t.Key())
+	if silence.Skip {
+		log.Debug().Msgf("skip silenced tls checker %s by silence %s", t.Key(), silence.ID)
+		return
+	}
+	stat, err := t.CollectTLSStatus(ch)
+	e.Inventory.Observe(t, stat, err)
+	e.setResult(t, startTime, err, silence)
+}
+
+// ProbeResult is the result of the last probe of a TLSChecker.
+type ProbeResult struct {
+	CheckedAt time.Time `json:"checkedAt"`
+	// Duration is the seconds of the probe.
+	Duration   float64 `json:"durationSeconds"`
+	Success    bool    `json:"success"`
+	Error      string  `json:"error,omitempty"`
+	ErrorClass string  `json:"errorClass,omitempty"`
+	// Silence is the ID of the silence matching the TLSChecker when it is probed.
+	Silence string `json:"silence,omitempty"`
+}
+
+// probeHistorySize is the count of the probe results kept for every TLSChecker.
+const probeHistorySize = 20
+
+func (e *Exporter) setResult(t *TLSChecker, startTime time.Time, err error, silence *Silence) {
+	result := &ProbeResult{
+		CheckedAt:  startTime,
+		Duration:   time.Since(startTime).Seconds(),
+		Success:    err == nil,
+		ErrorClass: ErrorClass(err),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if silence != nil {
+		result.Silence = silence.ID
+	}
+	e.resultsRWMutex.Lock()
+	defer e.resultsRWMutex.Unlock()
+	history := append(e.results[t.Key()], result)
+	if len(history) > probeHistorySize {
+		history = history[len(history)-probeHistorySize:]
+	}
+	e.results[t.Key()] = history
+}
+
+// Result returns the last probe result of the TLSChecker, nil when it is never probed.
+func (e *Exporter) Result(key string) *ProbeResult {
+	e.resultsRWMutex.RLock()
+	defer e.resultsRWMutex.RUnlock()
+	history := e.results[key]
+	if len(history) == 0 {
+		return nil
+	}
+	return history[len(history)-1]
+}
+
+// History returns the last probe results of the TLSChecker, the latest is the last.
+func (e *Exporter) History(key string) []*ProbeResult {
+	e.resultsRWMutex.RLock()
+	defer e.resultsRWMutex.RUnlock()
+	return append([]*ProbeResult{}, e.results[key]...)
+}
+
+// ProbeStale probes the TLSCheckers whose last probe is older than maxAge, the metrics are dropped.
+// The TLSCheckers probed by the scrapes are not probed again.
+func (e *Exporter) ProbeStale(maxAge time.Duration) {
+	e.CheckerRWMutex.RLock()
+	defer e.CheckerRWMutex.RUnlock()
+	wa := NewWaitPool(e.MaxCollectConnections)
+	for _, t := range e.TLSCheckers {
+		if r := e.Result(t.Key()); r != nil && time.Since(r.CheckedAt) < maxAge {
+			continue
+		}
+		wa.Run(e.probeTLSChecker, t)
+	}
+	wa.Wait()
+}
+
+func (e *Exporter) probeTLSChecker(t *TLSChecker) {
+	discardMetrics(func(ch chan<- prometheus.Metric) {
+		e.collectTLSChecker(t, ch)
+	})
+}
+
+// discardMetrics runs collect and drops the metrics.
+func discardMetrics(collect func(ch chan<- prometheus.Metric)) {
+	ch := make(chan prometheus.Metric, 128)
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(done)
+	}()
+	collect(ch)
+	close(ch)
+	<-done
+}
+
 func (e *Exporter) RemoveHostScanner(key string) {
 	e.HostScannerRWMutex.Lock()
 	defer e.HostScannerRWMutex.Unlock()
@@ -88,14 +380,17 @@ func (e *Exporter) RemoveHostScanner(key string) {
 func (e *Exporter) UpdateHostScannerConfig(ctx context.Context, s *HostScannerConfig, creator creator.Creator) {
 	e.HostScannerRWMutex.Lock()
 	defer e.HostScannerRWMutex.Unlock()
+	resolved := *s
+	resolved.TLSOptions = e.ResolveOptions(s.Module, s.TLSOptions)
 	oldHs, exists := e.HostScanners[s.Key()]
-	if exists && oldHs.Config != *s {
+	if exists && !reflect.DeepEqual(oldHs.Config, resolved) {
 		log.Info().Msgf("reload hostScanner: %s", s.Key())
 		oldHs.Stop()
+		exists = false
 	}
 	if !exists {
 		log.Info().Msgf("added hostScanner: %s", s.Key())
-		hostScanner := NewHostScanner(creator, s, e.waitPool, ctx)
+		hostScanner := NewHostScanner(creator, &resolved, s, e.waitPool, ctx)
 		e.HostScanners[s.Key()] = hostScanner
 		go hostScanner.Scan()
 	}
@@ -114,6 +409,11 @@ func (e *Exporter) RemoveTLSChecker(key string) {
 	defer e.CheckerRWMutex.Unlock()
 	log.Info().Msgf("delete tls checker: %s", key)
 	delete(e.TLSCheckers, key)
+	e.Inventory.Remove(key)
+	e.Notifier.Remove(key)
+	e.resultsRWMutex.Lock()
+	delete(e.results, key)
+	e.resultsRWMutex.Unlock()
 }
 
 func (e *Exporter) UpdateAutoDiscover(ctx context.Context, cfg *autodiscover.Config, creator creator.Creator) {
@@ -0,0 +1,150 @@
+package common
+
+import (
+	"context"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeTLSCheckOptions(t *testing.T) {
+	module := TLSCheckOptions{Timeout: 5000, InsecureSkipVerify: true, ReTryTimes: 2, CAFile: "/etc/ca.pem"}
+	merged := MergeTLSCheckOptions(TLSCheckOptions{Domain: "www.example.com", Timeout: 1000}, module)
+	expected := TLSCheckOptions{Domain: "www.example.com", Timeout: 1000, InsecureSkipVerify: true, ReTryTimes: 2, CAFile: "/etc/ca.pem"}
+	if merged != expected {
+		t.Fatalf("merged options should be %+v, but now is: %+v", expected, merged)
+	}
+}
+
+func TestMergeTLSCheckOptionsOverrideZero(t *testing.T) {
+	module := TLSCheckOptions{Timeout: 5000, InsecureSkipVerify: true, ReTryTimes: 2, CAFile: "/etc/ca.pem"}
+	var checker TLSChecker
+	spec := "host: 10.0.0.1\nport: 443\nTLSCheckOptions:\n  skipVerify: false\n  reTryTimes: 0\n  caFile: \"\"\n"
+	if err := yaml.Unmarshal([]byte(spec), &checker); err != nil {
+		t.Fatal(err)
+	}
+	if checker.Host != "10.0.0.1" || checker.Port != 443 {
+		t.Fatalf("unexpected checker: %+v", checker)
+	}
+	merged := MergeTLSCheckOptions(checker.TLSCheckOptions, module)
+	if merged.InsecureSkipVerify || merged.ReTryTimes != 0 || merged.CAFile != "" || merged.Timeout != 5000 {
+		t.Fatalf("the options set to zero should override the module, but now is: %+v", merged)
+	}
+
+	var cfg HostScannerConfig
+	if err := yaml.Unmarshal([]byte("host: 10.0.0.1\nTLSOptions:\n  skipVerify: false\n"), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if merged = MergeTLSCheckOptions(cfg.TLSOptions, module); merged.InsecureSkipVerify || merged.ReTryTimes != 2 {
+		t.Fatalf("only skipVerify should be overridden, but now is: %+v", merged)
+	}
+}
+
+func TestTLSCheckOptionsDefaultCert(t *testing.T) {
+	for _, v := range []string{"noSNI", "bogusSNI"} {
+		var o TLSCheckOptions
+		if err := yaml.Unmarshal([]byte("defaultCert: "+v), &o); err != nil || o.DefaultCert != v {
+			t.Fatalf("defaultCert %s should be accepted, but now is: %q, %v", v, o.DefaultCert, err)
+		}
+	}
+	for _, v := range []string{"nosni", "bogus-sni", "sni"} {
+		var o TLSCheckOptions
+		if err := yaml.Unmarshal([]byte("defaultCert: "+v), &o); err == nil {
+			t.Fatalf("defaultCert %s should be rejected", v)
+		}
+	}
+	var checker TLSChecker
+	if err := yaml.Unmarshal([]byte("host: 10.0.0.1\nTLSCheckOptions:\n  defaultCert: nosni\n"), &checker); err == nil {
+		t.Fatalf("the checker with an unknown defaultCert should be rejected")
+	}
+}
+
+func TestExporterModules(t *testing.T) {
+	e := NewExporter()
+	// a canceled context stops the scan immediately.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	changed := e.SetModules(map[string]TLSCheckOptions{
+		"slow":   {Timeout: 5000},
+		"unused": {Timeout: 1000},
+	})
+	if len(changed) != 2 {
+		t.Fatalf("changed modules should be 2, but now is: %v", changed)
+	}
+	cfg := HostScannerConfig{Host: "127.0.0.1", Module: "slow"}
+	e.UpdateHostScannerConfig(ctx, &cfg, nil)
+	hs := e.HostScanners[cfg.Key()]
+	if hs.Config.TLSOptions.Timeout != 5000 {
+		t.Fatalf("timeout should be resolved from module, but now is: %d", hs.Config.TLSOptions.Timeout)
+	}
+
+	changed = e.SetModules(map[string]TLSCheckOptions{
+		"slow":   {Timeout: 8000},
+		"unused": {Timeout: 1000},
+	})
+	if _, exists := changed["slow"]; !exists || len(changed) != 1 {
+		t.Fatalf("only slow module should be changed, but now is: %v", changed)
+	}
+	e.ReloadModuleDependents(changed)
+	reloaded := e.HostScanners[cfg.Key()]
+	if reloaded == hs || reloaded.Config.TLSOptions.Timeout != 8000 {
+		t.Fatalf("hostScanner should be reloaded with timeout 8000, but now is: %d", reloaded.Config.TLSOptions.Timeout)
+	}
+}
+
+func TestReloaderModuleDomain(t *testing.T) {
+	// a canceled context stops the scan immediately.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := &Reloader{ctx: ctx}
+	filename := filepath.Join(t.TempDir(), "config.yml")
+	load := func(config string) {
+		if err := os.WriteFile(filename, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.LoadConfig(filename); err != nil {
+			t.Fatal(err)
+		}
+	}
+	modules := "modules:\n  internal:\n    domain: internal.example.com\n    timeout: 1000\n"
+	load(modules + `
+hostScannersConfig:
+  - host: 127.0.0.1
+    module: internal
+TLSCheckers:
+  - host: 127.0.0.1
+    port: 443
+    module: internal
+`)
+	rawKey := (&HostScannerConfig{Host: "127.0.0.1"}).Key()
+	Exp.HostScannerRWMutex.RLock()
+	_, exists := Exp.HostScanners[rawKey]
+	Exp.HostScannerRWMutex.RUnlock()
+	if !exists {
+		t.Fatalf("hostScanner %s should be added", rawKey)
+	}
+	checker := &TLSChecker{Host: "127.0.0.1", Port: 443, TLSCheckOptions: TLSCheckOptions{Domain: "internal.example.com"}}
+	Exp.CheckerRWMutex.RLock()
+	added := Exp.TLSCheckers[checker.Key()]
+	Exp.CheckerRWMutex.RUnlock()
+	if added == nil || added.RawOptions.Domain != "" || added.Timeout != 1000 {
+		t.Fatalf("TLSChecker should be resolved from the module and keep the raw options, but now is: %+v", added)
+	}
+
+	// the hostScanner whose module sets the domain is removed with the config.
+	load(modules)
+	Exp.HostScannerRWMutex.RLock()
+	_, exists = Exp.HostScanners[rawKey]
+	Exp.HostScannerRWMutex.RUnlock()
+	if exists {
+		t.Fatalf("hostScanner %s should be removed", rawKey)
+	}
+	Exp.CheckerRWMutex.RLock()
+	_, exists = Exp.TLSCheckers[checker.Key()]
+	Exp.CheckerRWMutex.RUnlock()
+	if exists {
+		t.Fatalf("TLSChecker %s should be removed", checker.Key())
+	}
+}
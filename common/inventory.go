@@ -0,0 +1,252 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"sort"
+	"sync"
+	"time"
+)
+
+// InventoryEndpoint is the last certificate served by a TLSChecker's endpoint.
+type InventoryEndpoint struct {
+	Host        string
+	Port        uint
+	Domain      string
+	Owner       string
+	Labels      Labels
+	Fingerprint string
+	Certificate *x509.Certificate
+	// Chain is the first verified chain, or the certificates sent by the server when the chain is not verified,
+	// only the leaf certificate is restored from the state file.
+	Chain     []*x509.Certificate
+	UpdatedAt time.Time
+}
+
+// Name returns the endpoint as host:port/SNI.
+func (e *InventoryEndpoint) Name() string {
+	return fmt.Sprintf("%s:%d/%s", e.Host, e.Port, e.Domain)
+}
+
+// InventoryCertificate is a distinct certificate and all the endpoints serving it.
+type InventoryCertificate struct {
+	Fingerprint string
+	Certificate *x509.Certificate
+	Endpoints   []*InventoryEndpoint
+}
+
+// InventoryDomain is the certificates served by all hosts of a domain on the same port.
+type InventoryDomain struct {
+	Domain string
+	Port   uint
+	// Variants is the number of distinct certificates.
+	Variants int
+	// Newest is the fingerprint of the certificate with the latest NotBefore.
+	Newest string
+	// Stragglers are the endpoints which do not serve the newest certificate.
+	Stragglers []*InventoryEndpoint
+}
+
+// inventoryRestoreGrace is how long the restored endpoints are kept without a TLSChecker,
+// the hostScanners and autoDiscovers recreate their TLSCheckers from the state file in the meantime.
+const inventoryRestoreGrace = 10 * time.Minute
+
+// Inventory aggregates the probe results of all TLSCheckers by certificate fingerprint.
+type Inventory struct {
+	// endpoints key is TLSChecker.Key().
+	endpoints map[string]*InventoryEndpoint
+	// restoredAt is when the last endpoint is restored from the state file.
+	restoredAt time.Time
+	mux        *sync.RWMutex
+}
+
+func NewInventory() *Inventory {
+	return &Inventory{
+		endpoints: make(map[string]*InventoryEndpoint),
+		mux:       new(sync.RWMutex),
+	}
+}
+
+// Observe records the result of a probe, the endpoint is removed when the probe got no certificate.
+// A certificate failed to verify is still recorded, so an expired certificate stays in the inventory.
+func (i *Inventory) Observe(t *TLSChecker, stat *tls.ConnectionState, err error) {
+	if stat == nil || len(stat.PeerCertificates) == 0 {
+		i.Remove(t.Key())
+		return
+	}
+	cert := stat.PeerCertificates[0]
+	chain := stat.PeerCertificates
+	if len(stat.VerifiedChains) > 0 {
+		chain = stat.VerifiedChains[0]
+	}
+	endpoint := &InventoryEndpoint{
+		Host:        t.Host,
+		Port:        t.Port,
+		Domain:      t.TLSCheckOptions.Domain,
+		Owner:       t.GetOwner(),
+		Labels:      t.Labels,
+		Fingerprint: Fingerprint(cert),
+		Certificate: cert,
+		Chain:       chain,
+		UpdatedAt:   time.Now(),
+	}
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	i.endpoints[t.Key()] = endpoint
+}
+
+// Restore adds an endpoint restored from the state file.
+func (i *Inventory) Restore(key string, endpoint *InventoryEndpoint) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	i.endpoints[key] = endpoint
+	i.restoredAt = time.Now()
+}
+
+// Endpoints returns a copy of all endpoints, the key is TLSChecker.Key().
+func (i *Inventory) Endpoints() map[string]*InventoryEndpoint {
+	i.mux.RLock()
+	defer i.mux.RUnlock()
+	endpoints := make(map[string]*InventoryEndpoint, len(i.endpoints))
+	for key, e := range i.endpoints {
+		endpoints[key] = e
+	}
+	return endpoints
+}
+
+// Prune removes the endpoints whose TLSChecker does not exist, like the restored ones which are not found again.
+// Nothing is removed until inventoryRestoreGrace has passed since the restore.
+func (i *Inventory) Prune(checkers map[string]*TLSChecker, now time.Time) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	if now.Sub(i.restoredAt) < inventoryRestoreGrace {
+		return
+	}
+	for key := range i.endpoints {
+		if _, exists := checkers[key]; !exists {
+			delete(i.endpoints, key)
+		}
+	}
+}
+
+func (i *Inventory) Remove(key string) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	delete(i.endpoints, key)
+}
+
+// Certificates returns the distinct certificates sorted by NotAfter.
+func (i *Inventory) Certificates() []*InventoryCertificate {
+	i.mux.RLock()
+	certs := make(map[string]*InventoryCertificate)
+	for _, e := range i.endpoints {
+		c, exists := certs[e.Fingerprint]
+		if !exists {
+			c = &InventoryCertificate{
+				Fingerprint: e.Fingerprint,
+				Certificate: e.Certificate,
+			}
+			certs[e.Fingerprint] = c
+		}
+		c.Endpoints = append(c.Endpoints, e)
+	}
+	i.mux.RUnlock()
+
+	list := make([]*InventoryCertificate, 0, len(certs))
+	for _, c := range certs {
+		sort.Slice(c.Endpoints, func(a, b int) bool {
+			return c.Endpoints[a].Name() < c.Endpoints[b].Name()
+		})
+		list = append(list, c)
+	}
+	sort.Slice(list, func(a, b int) bool {
+		if list[a].Certificate.NotAfter.Equal(list[b].Certificate.NotAfter) {
+			return list[a].Fingerprint < list[b].Fingerprint
+		}
+		return list[a].Certificate.NotAfter.Before(list[b].Certificate.NotAfter)
+	})
+	return list
+}
+
+// Domains groups the endpoints by domain and port to find the hosts still serving an old certificate,
+// like some nodes are missed when a renewed certificate is rolled out.
+func (i *Inventory) Domains() []*InventoryDomain {
+	type domainPort struct {
+		domain string
+		port   uint
+	}
+	groups := make(map[domainPort][]*InventoryEndpoint)
+	i.mux.RLock()
+	for _, e := range i.endpoints {
+		key := domainPort{e.Domain, e.Port}
+		groups[key] = append(groups[key], e)
+	}
+	i.mux.RUnlock()
+
+	domains := make([]*InventoryDomain, 0, len(groups))
+	for key, endpoints := range groups {
+		d := &InventoryDomain{
+			Domain:     key.domain,
+			Port:       key.port,
+			Stragglers: make([]*InventoryEndpoint, 0),
+		}
+		var newest *x509.Certificate
+		fingerprints := make(map[string]struct{})
+		for _, e := range endpoints {
+			fingerprints[e.Fingerprint] = struct{}{}
+			if newest == nil || e.Certificate.NotBefore.After(newest.NotBefore) ||
+				(e.Certificate.NotBefore.Equal(newest.NotBefore) && e.Fingerprint > d.Newest) {
+				newest = e.Certificate
+				d.Newest = e.Fingerprint
+			}
+		}
+		d.Variants = len(fingerprints)
+		for _, e := range endpoints {
+			if e.Fingerprint != d.Newest {
+				d.Stragglers = append(d.Stragglers, e)
+			}
+		}
+		sort.Slice(d.Stragglers, func(a, b int) bool {
+			return d.Stragglers[a].Name() < d.Stragglers[b].Name()
+		})
+		domains = append(domains, d)
+	}
+	sort.Slice(domains, func(a, b int) bool {
+		if domains[a].Domain == domains[b].Domain {
+			return domains[a].Port < domains[b].Port
+		}
+		return domains[a].Domain < domains[b].Domain
+	})
+	return domains
+}
+
+func (i *Inventory) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range i.Certificates() {
+		certLabelValues := []string{
+			c.Fingerprint,
+			c.Certificate.Subject.String(),
+			c.Certificate.Issuer.String(),
+			fmt.Sprintf("%s", c.Certificate.DNSNames),
+		}
+		sendGauge(ch, descInventoryCertEndpoints, float64(len(c.Endpoints)), certLabelValues...)
+		if Exp.LegacyMetrics() {
+			sendGauge(ch, descV1CertEndpoints, float64(len(c.Endpoints)), certLabelValues...)
+		}
+		sendGauge(ch, descInventoryCertNotAfter, float64(c.Certificate.NotAfter.Unix()), certLabelValues...)
+		for _, e := range c.Endpoints {
+			sendLabeledGauge(ch, descInventoryCertEndpoint, 1, e.Labels, e.Host, fmt.Sprintf("%d", e.Port), e.Domain, c.Fingerprint)
+		}
+	}
+	for _, d := range i.Domains() {
+		port := fmt.Sprintf("%d", d.Port)
+		sendGauge(ch, descInventoryDomainVariants, float64(d.Variants), port, d.Domain)
+		if Exp.LegacyMetrics() {
+			sendGauge(ch, descV1DomainCertVariants, float64(d.Variants), d.Domain, port)
+		}
+		for _, e := range d.Stragglers {
+			sendLabeledGauge(ch, descInventoryDomainStraggler, 1, e.Labels, e.Host, port, d.Domain, e.Fingerprint, d.Newest)
+		}
+	}
+}
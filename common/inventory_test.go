@@ -0,0 +1,156 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"testing"
+	"time"
+)
+
+func TestInventoryCertificates(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	shared := newTestCert(t, &x509.Certificate{DNSNames: []string{"*.example.com"}}, ca)
+	expiring := newTestCert(t, &x509.Certificate{
+		DNSNames: []string{"old.example.com"},
+		NotAfter: time.Now().Add(time.Hour),
+	}, ca)
+
+	inventory := NewInventory()
+	observe := func(host string, domain string, cert *testCert, err error) *TLSChecker {
+		checker := NewTLSChecker(nil, host, 443, TLSCheckOptions{Domain: domain})
+		stat := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert.cert}}
+		if err != nil {
+			// a failed handshake gets no certificate.
+			stat = nil
+		}
+		inventory.Observe(checker, stat, err)
+		return checker
+	}
+	observe("10.0.0.1", "a.example.com", shared, nil)
+	observe("10.0.0.2", "a.example.com", shared, nil)
+	removed := observe("10.0.0.3", "b.example.com", shared, nil)
+	observe("10.0.0.4", "old.example.com", expiring, nil)
+	observe("10.0.0.5", "c.example.com", shared, errors.New("connection refused"))
+	inventory.Remove(removed.Key())
+
+	certs := inventory.Certificates()
+	if len(certs) != 2 {
+		t.Fatalf("distinct certificates should be 2, but now is: %d", len(certs))
+	}
+	// sorted by NotAfter.
+	if certs[0].Fingerprint != Fingerprint(expiring.cert) || len(certs[0].Endpoints) != 1 {
+		t.Fatalf("first certificate should be the expiring one with 1 endpoint, got: %s %d", certs[0].Fingerprint, len(certs[0].Endpoints))
+	}
+	if len(certs[1].Endpoints) != 2 {
+		t.Fatalf("shared certificate endpoints should be 2, but now is: %d", len(certs[1].Endpoints))
+	}
+	if name := certs[1].Endpoints[0].Name(); name != "10.0.0.1:443/a.example.com" {
+		t.Fatalf("wrong endpoint name: %s", name)
+	}
+}
+
+func TestInventoryDomains(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	old := newTestCert(t, &x509.Certificate{
+		DNSNames:  []string{"www.example.com"},
+		NotBefore: time.Now().Add(-30 * 24 * time.Hour),
+	}, ca)
+	renewed := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com"}}, ca)
+
+	inventory := NewInventory()
+	for host, cert := range map[string]*testCert{
+		"10.0.0.1": renewed,
+		"10.0.0.2": renewed,
+		"10.0.0.3": old,
+	} {
+		checker := NewTLSChecker(nil, host, 443, TLSCheckOptions{Domain: "www.example.com"})
+		inventory.Observe(checker, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert.cert}}, nil)
+	}
+	// another port of the same domain is not compared with 443.
+	checker := NewTLSChecker(nil, "10.0.0.1", 8443, TLSCheckOptions{Domain: "www.example.com"})
+	inventory.Observe(checker, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{old.cert}}, nil)
+
+	domains := inventory.Domains()
+	if len(domains) != 2 {
+		t.Fatalf("domains should be 2, but now is: %d", len(domains))
+	}
+	d := domains[0]
+	if d.Port != 443 || d.Variants != 2 || d.Newest != Fingerprint(renewed.cert) {
+		t.Fatalf("wrong domain result: port %d, variants %d, newest %s", d.Port, d.Variants, d.Newest)
+	}
+	if len(d.Stragglers) != 1 || d.Stragglers[0].Host != "10.0.0.3" {
+		t.Fatalf("straggler should be 10.0.0.3, got: %v", d.Stragglers)
+	}
+	if domains[1].Variants != 1 || len(domains[1].Stragglers) != 0 {
+		t.Fatalf("port 8443 should not have stragglers, got: %v", domains[1].Stragglers)
+	}
+}
+
+func TestInventoryPruneRestored(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	leaf := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com"}}, ca)
+	inventory := NewInventory()
+	checker := NewTLSChecker(nil, "10.0.0.1", 443, TLSCheckOptions{Domain: "www.example.com"})
+	inventory.Restore(checker.Key(), &InventoryEndpoint{Host: "10.0.0.1", Port: 443, Domain: "www.example.com",
+		Fingerprint: Fingerprint(leaf.cert), Certificate: leaf.cert})
+	inventory.Restore("10.0.0.2:443/www.example.com", &InventoryEndpoint{Host: "10.0.0.2", Port: 443, Domain: "www.example.com",
+		Fingerprint: Fingerprint(leaf.cert), Certificate: leaf.cert})
+	checkers := map[string]*TLSChecker{checker.Key(): checker}
+
+	// the restored endpoints are kept until their TLSCheckers are recreated.
+	inventory.Prune(checkers, time.Now())
+	if endpoints := inventory.Endpoints(); len(endpoints) != 2 {
+		t.Fatalf("restored endpoints should be kept during the grace period, but now is: %d", len(endpoints))
+	}
+	inventory.Prune(checkers, time.Now().Add(inventoryRestoreGrace))
+	if endpoints := inventory.Endpoints(); len(endpoints) != 1 || endpoints[checker.Key()] == nil {
+		t.Fatalf("endpoint without TLSChecker should be pruned after the grace period, but now is: %v", endpoints)
+	}
+}
+
+// inventoryCollector collects the Inventory with the descriptors of the schema.
+type inventoryCollector struct {
+	*Inventory
+}
+
+func (c inventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range exporterDescs {
+		ch <- d
+	}
+}
+
+func TestInventoryLegacyMetrics(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	leaf := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com"}}, ca)
+	inventory := NewInventory()
+	checker := NewTLSChecker(nil, "10.0.0.1", 443, TLSCheckOptions{Domain: "www.example.com"})
+	inventory.Observe(checker, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf.cert}}, nil)
+	defer Exp.SetLegacyMetrics(false)
+
+	for _, legacy := range []bool{false, true} {
+		Exp.SetLegacyMetrics(legacy)
+		registry := prometheus.NewPedanticRegistry()
+		if err := registry.Register(inventoryCollector{inventory}); err != nil {
+			t.Fatal(err)
+		}
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatalf("legacy: %v, gather failed: %v", legacy, err)
+		}
+		names := make(map[string]struct{})
+		for _, f := range families {
+			names[f.GetName()] = struct{}{}
+		}
+		for _, name := range []string{"tls_cert_endpoints", "tls_domain_cert_variants"} {
+			if _, exists := names[name]; exists != legacy {
+				t.Fatalf("%s should be exported only when legacy metrics is enabled, legacy: %v", name, legacy)
+			}
+		}
+		if _, exists := names["tlsprobe_inventory_cert_endpoints"]; !exists {
+			t.Fatalf("legacy: %v, tlsprobe_inventory_cert_endpoints should be exported", legacy)
+		}
+	}
+}
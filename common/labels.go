@@ -0,0 +1,208 @@
+package common
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Labels are the extra labels like team, env and service,
+// they are added to every metric of the endpoints, hostScanners and autoDiscovers.
+type Labels map[string]string
+
+// Merge returns a copy of the labels whose keys are overridden by override.
+func (l Labels) Merge(override Labels) Labels {
+	if len(l) == 0 && len(override) == 0 {
+		return nil
+	}
+	merged := make(Labels, len(l)+len(override))
+	for k, v := range l {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
+
+// metricValues returns the values of names, the missing labels are empty.
+func (l Labels) metricValues(names []string) []string {
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = l[name]
+	}
+	return values
+}
+
+// OwnerLabel is the label used as the owner of an endpoint when its owner is not set.
+const OwnerLabel = "team"
+
+// metricLabelNames are the extra label names of the metrics, they are fixed after the metrics are described.
+var metricLabelNames []string
+
+// reservedLabelNames are the endpoint labels identifying the series, the extra label names can not use them.
+var reservedLabelNames = map[string]struct{}{"host": {}, "port": {}, "domain": {}, "sni": {}, "cidr": {}}
+
+// descMetricLabelNames are the extra label names of every descriptor created by newLabeledDesc,
+// an extra label name which is a builtin label of the descriptor is not added to it.
+var descMetricLabelNames = make(map[*prometheus.Desc][]string)
+
+var labelNameRegexp = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// newLabeledDesc creates a descriptor whose labels are labelNames followed by metricLabelNames.
+func newLabeledDesc(fqName string, help string, labelNames []string) *prometheus.Desc {
+	builtin := make(map[string]struct{}, len(labelNames))
+	for _, name := range labelNames {
+		builtin[name] = struct{}{}
+	}
+	extra := make([]string, 0, len(metricLabelNames))
+	for _, name := range metricLabelNames {
+		if _, exists := builtin[name]; !exists {
+			extra = append(extra, name)
+		}
+	}
+	names := make([]string, 0, len(labelNames)+len(extra))
+	desc := prometheus.NewDesc(fqName, help, append(append(names, labelNames...), extra...), nil)
+	descMetricLabelNames[desc] = extra
+	return desc
+}
+
+// ValidateLabelNames checks the extra label names are valid Prometheus label names and are not the endpoint labels.
+func ValidateLabelNames(names []string) error {
+	for _, name := range names {
+		if !labelNameRegexp.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name %q, it should match %s and not start with __", name, labelNameRegexp)
+		}
+		if _, exists := reservedLabelNames[name]; exists {
+			return fmt.Errorf("label name %q is reserved by the endpoint labels", name)
+		}
+	}
+	return nil
+}
+
+// validateKnownLabels checks the labels of an object created at runtime, the label names must be exported already,
+// because the descriptors can not be changed after they are described.
+func validateKnownLabels(labels Labels) error {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if err := ValidateLabelNames(names); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !containsString(metricLabelNames, name) {
+			return fmt.Errorf("unknown label name %q, the label names of the config are %v", name, metricLabelNames)
+		}
+	}
+	return nil
+}
+
+// SetMetricLabelNames validates the label names and recreates the descriptors with them.
+// The registered descriptors can not be changed, so after the exporter is described,
+// a new label name is an error and needs a restart.
+func (e *Exporter) SetMetricLabelNames(names []string) error {
+	if err := ValidateLabelNames(names); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(names, metricLabelNames) || (len(names) == 0 && len(metricLabelNames) == 0) {
+		return nil
+	}
+	if atomic.LoadInt32(&e.described) == 1 {
+		known := make(map[string]struct{}, len(metricLabelNames))
+		for _, name := range metricLabelNames {
+			known[name] = struct{}{}
+		}
+		for _, name := range names {
+			if _, exists := known[name]; !exists {
+				return fmt.Errorf("new label name %q needs a restart, the exported label names are %v", name, metricLabelNames)
+			}
+		}
+		// the removed label names are still exported with empty values.
+		return nil
+	}
+	initMetricDescs(names)
+	return nil
+}
+
+// CollectLabelNames returns the sorted label names used by the config.
+func CollectLabelNames(cfg *Config) []string {
+	names := make(map[string]struct{})
+	add := func(labels map[string]string) {
+		for name := range labels {
+			names[name] = struct{}{}
+		}
+	}
+	for _, t := range cfg.TLSCheckers {
+		add(t.Labels)
+	}
+	for _, s := range cfg.HostScannersConfig {
+		add(s.Labels)
+	}
+	for _, a := range cfg.AutoDiscover {
+		add(a.Labels)
+	}
+	for _, r := range cfg.Ownership {
+		add(r.Labels)
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// OwnershipRule adds the labels to the discovered records whose domain matches the pattern,
+// "*.pay.example.com" matches all subdomains of pay.example.com.
+type OwnershipRule struct {
+	Pattern string `yaml:"pattern"`
+	Labels  Labels `yaml:"labels"`
+}
+
+func (r *OwnershipRule) Match(domain string) bool {
+	pattern, domain := strings.ToLower(r.Pattern), strings.ToLower(strings.TrimSuffix(domain, "."))
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(domain, pattern[1:])
+	}
+	return pattern == domain
+}
+
+// OwnershipLabels returns the labels of the first rule matching the domain.
+func (e *Exporter) OwnershipLabels(domain string) Labels {
+	e.ownershipRWMutex.RLock()
+	defer e.ownershipRWMutex.RUnlock()
+	for i := range e.ownership {
+		if e.ownership[i].Match(domain) {
+			return e.ownership[i].Labels
+		}
+	}
+	return nil
+}
+
+// GetOwner returns the owner of the TLSChecker, it falls back to the team label,
+// so the endpoints labeled by the ownership rules are grouped by their team.
+func (t *TLSChecker) GetOwner() string {
+	if t.Owner != "" {
+		return t.Owner
+	}
+	return t.Labels[OwnerLabel]
+}
+
+func (e *Exporter) SetOwnershipRules(rules []OwnershipRule) {
+	e.ownershipRWMutex.Lock()
+	defer e.ownershipRWMutex.Unlock()
+	e.ownership = rules
+}
+
+// sendLabeledGauge sends a gauge of the descriptor created by newLabeledDesc, labelValues are followed by the values of labels.
+func sendLabeledGauge(ch chan<- prometheus.Metric, desc *prometheus.Desc, value float64, labels Labels, labelValues ...string) {
+	names := descMetricLabelNames[desc]
+	values := make([]string, 0, len(labelValues)+len(names))
+	sendGauge(ch, desc, value, append(append(values, labelValues...), labels.metricValues(names)...)...)
+}
@@ -0,0 +1,188 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/prometheus/client_golang/prometheus"
+	"reflect"
+	"testing"
+)
+
+func TestOwnershipRuleMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		domain  string
+		match   bool
+	}{
+		{"*.pay.example.com", "api.pay.example.com", true},
+		{"*.pay.example.com", "a.b.pay.example.com.", true},
+		{"*.pay.example.com", "pay.example.com", false},
+		{"*.pay.example.com", "xpay.example.com", false},
+		{"WWW.example.com", "www.example.com", true},
+		{"www.example.com", "api.example.com", false},
+	}
+	for _, c := range cases {
+		rule := OwnershipRule{Pattern: c.pattern}
+		if match := rule.Match(c.domain); match != c.match {
+			t.Fatalf("%s match %s should be %v, but now is: %v", c.pattern, c.domain, c.match, match)
+		}
+	}
+
+	e := NewExporter()
+	e.SetOwnershipRules([]OwnershipRule{
+		{Pattern: "*.pay.example.com", Labels: Labels{"team": "payments"}},
+		{Pattern: "*.example.com", Labels: Labels{"team": "web"}},
+	})
+	if labels := e.OwnershipLabels("api.pay.example.com"); labels["team"] != "payments" {
+		t.Fatalf("the first matched rule should be used, but now is: %v", labels)
+	}
+	if labels := e.OwnershipLabels("www.other.com"); labels != nil {
+		t.Fatalf("no rule should match, but now is: %v", labels)
+	}
+}
+
+func TestCollectLabelNames(t *testing.T) {
+	cfg := Config{
+		TLSCheckers:        []TLSChecker{{Labels: Labels{"team": "a", "env": "prod"}}},
+		HostScannersConfig: []HostScannerConfig{{Labels: Labels{"service": "api"}}},
+		Ownership:          []OwnershipRule{{Pattern: "*.example.com", Labels: Labels{"team": "b"}}},
+	}
+	if names := CollectLabelNames(&cfg); !reflect.DeepEqual(names, []string{"env", "service", "team"}) {
+		t.Fatalf("label names should be [env service team], but now is: %v", names)
+	}
+	merged := Labels{"team": "a", "env": "prod"}.Merge(Labels{"team": "b"})
+	if !reflect.DeepEqual(merged, Labels{"team": "b", "env": "prod"}) {
+		t.Fatalf("merged labels should be overridden, but now is: %v", merged)
+	}
+}
+
+func TestMetricLabels(t *testing.T) {
+	initMetricDescs([]string{"env", "team"})
+	defer initMetricDescs(nil)
+
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	leaf := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com"}}, ca)
+	port := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{leaf.TLSCertificate()}})
+	checker := NewTLSChecker(nil, "127.0.0.1", port, TLSCheckOptions{Domain: "www.example.com", InsecureSkipVerify: true})
+	checker.Labels = Labels{"team": "payments"}
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(checkersCollector{checker}); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != "tlsprobe_check_success" {
+			continue
+		}
+		labels := make(map[string]string)
+		for _, l := range f.GetMetric()[0].GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		if labels["team"] != "payments" || labels["domain"] != "www.example.com" {
+			t.Fatalf("the labels of the checker should be exported, but now is: %v", labels)
+		}
+		if value, exists := labels["env"]; !exists || value != "" {
+			t.Fatalf("the missing label should be empty, but now is: %v", labels)
+		}
+		return
+	}
+	t.Fatal("tlsprobe_check_success should be exported")
+}
+
+func TestValidateLabelNames(t *testing.T) {
+	cases := []struct {
+		names []string
+		valid bool
+	}{
+		{nil, true},
+		{[]string{"team", "env", "service_name"}, true},
+		{[]string{"team-name"}, false},
+		{[]string{"1team"}, false},
+		{[]string{"__team"}, false},
+		{[]string{"host"}, false},
+		{[]string{"port"}, false},
+		{[]string{"domain"}, false},
+		{[]string{"sni"}, false},
+		{[]string{"status"}, true},
+		{[]string{"class"}, true},
+		{[]string{"name", "type"}, true},
+	}
+	for _, c := range cases {
+		if err := ValidateLabelNames(c.names); (err == nil) != c.valid {
+			t.Fatalf("%v: valid should be %v, but now is: %v", c.names, c.valid, err)
+		}
+	}
+}
+
+func TestMetricLabelsBuiltinName(t *testing.T) {
+	initMetricDescs([]string{"team", "type"})
+	defer initMetricDescs(nil)
+	if err := prometheus.NewPedanticRegistry().Register(NewExporter()); err != nil {
+		t.Fatalf("the builtin label of a descriptor should not be duplicated: %v", err)
+	}
+
+	labels := Labels{"team": "payments", "type": "web"}
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(funcCollector(func(ch chan<- prometheus.Metric) {
+		sendLabeledGauge(ch, descCheckSuccess, 1, labels, "127.0.0.1", "443", "www.example.com")
+		sendLabeledGauge(ch, descAutoDiscoverRecords, 1, labels, "aliyun", "aliyun")
+	})); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		exported := make(map[string]string)
+		for _, l := range f.GetMetric()[0].GetLabel() {
+			exported[l.GetName()] = l.GetValue()
+		}
+		if f.GetName() == "tlsprobe_check_success" && (exported["type"] != "web" || exported["team"] != "payments") {
+			t.Fatalf("the type label should be exported, but now is: %v", exported)
+		}
+		if f.GetName() == "tlsprobe_autodiscover_records" && (exported["type"] != "aliyun" || exported["team"] != "payments") {
+			t.Fatalf("the builtin type label should be kept, but now is: %v", exported)
+		}
+	}
+}
+
+// funcCollector is an unchecked collector which collects by the function.
+type funcCollector func(ch chan<- prometheus.Metric)
+
+func (c funcCollector) Describe(ch chan<- *prometheus.Desc) {}
+func (c funcCollector) Collect(ch chan<- prometheus.Metric) { c(ch) }
+
+func TestSetMetricLabelNamesAfterDescribed(t *testing.T) {
+	defer initMetricDescs(nil)
+	e := NewExporter()
+	if err := e.SetMetricLabelNames([]string{"team"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := prometheus.NewPedanticRegistry().Register(e); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetMetricLabelNames([]string{"env", "team"}); err == nil {
+		t.Fatal("a new label name after the exporter is described should fail")
+	}
+	if err := e.SetMetricLabelNames(nil); err != nil {
+		t.Fatalf("removing a label name should not fail, but now is: %v", err)
+	}
+}
+
+func TestTLSCheckerGetOwner(t *testing.T) {
+	checker := NewTLSChecker(nil, "127.0.0.1", 443, TLSCheckOptions{})
+	checker.Labels = Labels{OwnerLabel: "payments"}
+	if owner := checker.GetOwner(); owner != "payments" {
+		t.Fatalf("owner should fall back to the team label, but now is: %q", owner)
+	}
+	checker.Owner = "web"
+	if owner := checker.GetOwner(); owner != "web" {
+		t.Fatalf("owner should be used first, but now is: %q", owner)
+	}
+}
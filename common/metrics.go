@@ -0,0 +1,295 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// MetricSchemaVersion is bumped when a metric or a label of the schema is renamed or removed.
+const MetricSchemaVersion = "2"
+
+// The bounded classes of the check errors, the error message is only logged.
+const (
+	ErrorClassTimeout          = "timeout"
+	ErrorClassRefused          = "connection_refused"
+	ErrorClassDNS              = "dns"
+	ErrorClassNotTLS           = "not_tls"
+	ErrorClassEOF              = "eof"
+	ErrorClassHandshake        = "handshake"
+	ErrorClassUnknownAuthority = "unknown_authority"
+	ErrorClassHostname         = "hostname_mismatch"
+	ErrorClassExpired          = "expired"
+	ErrorClassInvalidCert      = "invalid_cert"
+	ErrorClassOther            = "other"
+)
+
+var (
+	endpointLabelNames      = []string{"host", "port", "domain"}
+	defaultCertLabelNames   = []string{"host", "port", "domain", "sni"}
+	inventoryCertLabelNames = []string{"fingerprint", "subject", "issuer", "dns_names"}
+)
+
+var (
+	descSchemaInfo                *prometheus.Desc
+	descCheckSuccess              *prometheus.Desc
+	descCheckError                *prometheus.Desc
+	descCertInfo                  *prometheus.Desc
+	descCertNotAfter              *prometheus.Desc
+	descCertNotBefore             *prometheus.Desc
+	descChainIncomplete           *prometheus.Desc
+	descChainNotAfter             *prometheus.Desc
+	descLegacyVerified            *prometheus.Desc
+	descExpectationSuccess        *prometheus.Desc
+	descExpectationFailed         *prometheus.Desc
+	descCertExpiresIn             *prometheus.Desc
+	descCertStatus                *prometheus.Desc
+	descDefaultCertSuccess        *prometheus.Desc
+	descDefaultCertInfo           *prometheus.Desc
+	descDefaultCertNotAfter       *prometheus.Desc
+	descSilenced                  *prometheus.Desc
+	descScannerPort               *prometheus.Desc
+	descScannerRangeProgress      *prometheus.Desc
+	descScannerPortsScanned       *prometheus.Desc
+	descScannerPortsTotal         *prometheus.Desc
+	descScannerScanStart          *prometheus.Desc
+	descScannerScanFinish         *prometheus.Desc
+	descInventoryCertEndpoints    *prometheus.Desc
+	descInventoryCertNotAfter     *prometheus.Desc
+	descInventoryCertEndpoint     *prometheus.Desc
+	descInventoryDomainVariants   *prometheus.Desc
+	descInventoryDomainStraggler  *prometheus.Desc
+	descProbeSuccess              *prometheus.Desc
+	descProbeDuration             *prometheus.Desc
+	descWaitPoolInFlight          *prometheus.Desc
+	descWaitPoolQueued            *prometheus.Desc
+	descWaitPoolLimit             *prometheus.Desc
+	descAutoDiscoverRecords       *prometheus.Desc
+	descAutoDiscoverLastSuccess   *prometheus.Desc
+	descAutoDiscoverRefreshFailed *prometheus.Desc
+	descCollectDuration           *prometheus.Desc
+	descV1Checker                 *prometheus.Desc
+	descV1NotAfter                *prometheus.Desc
+	descV1NotBefore               *prometheus.Desc
+	descV1ChainIncomplete         *prometheus.Desc
+	descV1ChainNotAfter           *prometheus.Desc
+	descV1LegacyVerified          *prometheus.Desc
+	descV1Expectation             *prometheus.Desc
+	descV1DefaultCert             *prometheus.Desc
+	descV1DefaultCertNotAfter     *prometheus.Desc
+	descV1ScannerPort             *prometheus.Desc
+	descV1CertExpiresIn           *prometheus.Desc
+	descV1CertEndpoints           *prometheus.Desc
+	descV1DomainCertVariants      *prometheus.Desc
+)
+
+// checkerDescs are the descriptors exported by TLSChecker.CollectTLSStatus, and exporterDescs are
+// the descriptors exported by the Exporter besides checkerDescs.
+var checkerDescs, exporterDescs []*prometheus.Desc
+
+func init() {
+	initMetricDescs(nil)
+}
+
+// initMetricDescs creates all descriptors, labelNames are the extra labels of the endpoints, hostScanners and autoDiscovers.
+func initMetricDescs(labelNames []string) {
+	metricLabelNames = labelNames
+	descMetricLabelNames = make(map[*prometheus.Desc][]string)
+
+	descSchemaInfo = prometheus.NewDesc("tlsprobe_metric_schema_info",
+		"The version of the metric schema.", nil, prometheus.Labels{"version": MetricSchemaVersion})
+
+	// TLSChecker
+	descCheckSuccess = newLabeledDesc("tlsprobe_check_success",
+		"Whether the TLS check succeeded.", endpointLabelNames)
+	descCheckError = newLabeledDesc("tlsprobe_check_error",
+		"The class of the check error, only exported when the check failed.", append(endpointLabelNames, "class"))
+	descCertInfo = newLabeledDesc("tlsprobe_cert_info",
+		"The metadata of the leaf certificate.", append(endpointLabelNames, "fingerprint", "subject", "issuer", "serial", "dns_names"))
+	descCertNotAfter = newLabeledDesc("tlsprobe_cert_not_after_seconds",
+		"The NotAfter of the leaf certificate.", endpointLabelNames)
+	descCertNotBefore = newLabeledDesc("tlsprobe_cert_not_before_seconds",
+		"The NotBefore of the leaf certificate.", endpointLabelNames)
+	descChainIncomplete = newLabeledDesc("tlsprobe_chain_incomplete",
+		"Whether the server does not send the intermediates.", endpointLabelNames)
+	descChainNotAfter = newLabeledDesc("tlsprobe_chain_not_after_seconds",
+		"The earliest NotAfter of every verified chain.", append(endpointLabelNames, "chain", "root", "root_fingerprint"))
+	descLegacyVerified = newLabeledDesc("tlsprobe_legacy_verified",
+		"Whether the certificate is verified by the legacy roots.", endpointLabelNames)
+	descExpectationSuccess = newLabeledDesc("tlsprobe_expectation_success",
+		"Whether the certificate meets all expectations.", endpointLabelNames)
+	descExpectationFailed = newLabeledDesc("tlsprobe_expectation_failed",
+		"The failed expectation rules.", append(endpointLabelNames, "rule"))
+	descCertExpiresIn = newLabeledDesc("tlsprobe_cert_expires_in_seconds",
+		"The seconds before NotAfter of the leaf certificate, negative when it is expired.", endpointLabelNames)
+	descCertStatus = newLabeledDesc("tlsprobe_cert_status",
+		"The status of the leaf certificate by the expiry thresholds, 1 for the current status.", append(endpointLabelNames, "status"))
+	descDefaultCertSuccess = newLabeledDesc("tlsprobe_default_cert_success",
+		"Whether the default certificate check succeeded.", defaultCertLabelNames)
+	descDefaultCertInfo = newLabeledDesc("tlsprobe_default_cert_info",
+		"The metadata of the default certificate.", append(defaultCertLabelNames, "fingerprint", "subject", "dns_names"))
+	descDefaultCertNotAfter = newLabeledDesc("tlsprobe_default_cert_not_after_seconds",
+		"The NotAfter of the default certificate.", defaultCertLabelNames)
+
+	descSilenced = newLabeledDesc("tlsprobe_silenced",
+		"The silence matching the TLSChecker, skipped is true when the TLSChecker is not probed.", append(endpointLabelNames, "silence", "skipped"))
+
+	// HostScanner
+	descScannerPort = newLabeledDesc("tlsprobe_host_scanner_port",
+		"The open TLS ports found by the hostScanner.", endpointLabelNames)
+	descScannerRangeProgress = newLabeledDesc("tlsprobe_host_scanner_range_progress",
+		"The progress of the full scan of a CIDR.", []string{"cidr", "domain"})
+	descScannerPortsScanned = newLabeledDesc("tlsprobe_host_scanner_ports_scanned",
+		"The checked ports of the full scan.", []string{"host", "domain"})
+	descScannerPortsTotal = newLabeledDesc("tlsprobe_host_scanner_ports_total",
+		"The ports to check of the full scan.", []string{"host", "domain"})
+	descScannerScanStart = newLabeledDesc("tlsprobe_host_scanner_last_scan_start_timestamp_seconds",
+		"The start time of the last full scan.", []string{"host", "domain"})
+	descScannerScanFinish = newLabeledDesc("tlsprobe_host_scanner_last_scan_finish_timestamp_seconds",
+		"The finish time of the last full scan.", []string{"host", "domain"})
+
+	// Inventory
+	descInventoryCertEndpoints = prometheus.NewDesc("tlsprobe_inventory_cert_endpoints",
+		"The count of the endpoints serving the certificate.", inventoryCertLabelNames, nil)
+	descInventoryCertNotAfter = prometheus.NewDesc("tlsprobe_inventory_cert_not_after_seconds",
+		"The NotAfter of the certificate.", inventoryCertLabelNames, nil)
+	descInventoryCertEndpoint = newLabeledDesc("tlsprobe_inventory_cert_endpoint",
+		"The endpoints serving the certificate.", append(endpointLabelNames, "fingerprint"))
+	descInventoryDomainVariants = prometheus.NewDesc("tlsprobe_domain_cert_variants",
+		"The count of the different certificates served for the domain.", []string{"port", "domain"}, nil)
+	descInventoryDomainStraggler = newLabeledDesc("tlsprobe_domain_cert_straggler",
+		"The hosts still serving an old certificate.", append(endpointLabelNames, "fingerprint", "newest_fingerprint"))
+
+	// probe handler
+	descProbeSuccess = prometheus.NewDesc("tlsprobe_probe_success",
+		"Whether the probe succeeded.", endpointLabelNames, nil)
+	descProbeDuration = prometheus.NewDesc("tlsprobe_probe_duration_seconds",
+		"The duration of the probe.", endpointLabelNames, nil)
+
+	// self observability
+	descWaitPoolInFlight = prometheus.NewDesc("tlsprobe_wait_pool_in_flight",
+		"The running scan connections.", nil, nil)
+	descWaitPoolQueued = prometheus.NewDesc("tlsprobe_wait_pool_queued",
+		"The scan connections waiting for maxConnections.", nil, nil)
+	descWaitPoolLimit = prometheus.NewDesc("tlsprobe_wait_pool_limit",
+		"The maxConnections of the scans.", nil, nil)
+	descAutoDiscoverRecords = newLabeledDesc("tlsprobe_autodiscover_records",
+		"The records got by the last successful refresh.", []string{"name", "type"})
+	descAutoDiscoverLastSuccess = newLabeledDesc("tlsprobe_autodiscover_last_success_timestamp_seconds",
+		"The time of the last successful refresh.", []string{"name", "type"})
+	descAutoDiscoverRefreshFailed = newLabeledDesc("tlsprobe_autodiscover_last_refresh_failed",
+		"Whether the last refresh failed.", []string{"name", "type"})
+	descCollectDuration = prometheus.NewDesc("tlsprobe_collect_duration_seconds",
+		"The duration of the collect.", nil, nil)
+
+	// legacy series of schema 1, they are only exported when legacyMetrics is enabled.
+	// The empty labels are dropped by Prometheus, so the series are the same as before.
+	descV1Checker = prometheus.NewDesc("tls_checker",
+		"Deprecated: use tlsprobe_check_success and tlsprobe_cert_info.", []string{"port", "host", "error", "domain", "CertDNSNames", "NotBefore", "NotAfter"}, nil)
+	descV1NotAfter = prometheus.NewDesc("tls_checker_not_after",
+		"Deprecated: use tlsprobe_cert_not_after_seconds.", []string{"port", "host", "domain"}, nil)
+	descV1NotBefore = prometheus.NewDesc("tls_checker_not_before",
+		"Deprecated: use tlsprobe_cert_not_before_seconds.", []string{"port", "host", "domain"}, nil)
+	descV1ChainIncomplete = prometheus.NewDesc("tls_checker_chain_incomplete",
+		"Deprecated: use tlsprobe_chain_incomplete.", []string{"port", "host", "domain"}, nil)
+	descV1ChainNotAfter = prometheus.NewDesc("tls_checker_chain_not_after",
+		"Deprecated: use tlsprobe_chain_not_after_seconds.", []string{"port", "host", "domain", "chain", "root", "rootFingerprint"}, nil)
+	descV1LegacyVerified = prometheus.NewDesc("tls_checker_legacy_verified",
+		"Deprecated: use tlsprobe_legacy_verified.", []string{"port", "host", "domain", "error"}, nil)
+	descV1Expectation = prometheus.NewDesc("tls_checker_expectation",
+		"Deprecated: use tlsprobe_expectation_success and tlsprobe_expectation_failed.", []string{"port", "host", "domain", "failedRules"}, nil)
+	descV1DefaultCert = prometheus.NewDesc("tls_checker_default_cert",
+		"Deprecated: use tlsprobe_default_cert_success and tlsprobe_default_cert_info.", []string{"port", "host", "error", "domain", "sni", "CertDNSNames", "NotBefore", "NotAfter"}, nil)
+	descV1DefaultCertNotAfter = prometheus.NewDesc("tls_checker_default_cert_not_after",
+		"Deprecated: use tlsprobe_default_cert_not_after_seconds.", []string{"port", "host", "domain", "sni"}, nil)
+	descV1ScannerPort = prometheus.NewDesc("host_scanner_port",
+		"Deprecated: use tlsprobe_host_scanner_port.", []string{"port", "host", "domain"}, nil)
+	descV1CertExpiresIn = prometheus.NewDesc("tls_cert_expires_in_seconds",
+		"Deprecated: use tlsprobe_cert_expires_in_seconds.", []string{"host", "port", "domain"}, nil)
+	descV1CertEndpoints = prometheus.NewDesc("tls_cert_endpoints",
+		"Deprecated: use tlsprobe_inventory_cert_endpoints.", []string{"fingerprint", "subject", "issuer", "CertDNSNames"}, nil)
+	descV1DomainCertVariants = prometheus.NewDesc("tls_domain_cert_variants",
+		"Deprecated: use tlsprobe_domain_cert_variants.", []string{"domain", "port"}, nil)
+
+	checkerDescs = []*prometheus.Desc{
+		descCheckSuccess, descCheckError, descCertInfo, descCertNotAfter, descCertNotBefore,
+		descChainIncomplete, descChainNotAfter, descLegacyVerified, descExpectationSuccess, descExpectationFailed,
+		descCertExpiresIn, descCertStatus,
+		descDefaultCertSuccess, descDefaultCertInfo, descDefaultCertNotAfter,
+		descV1Checker, descV1NotAfter, descV1NotBefore, descV1ChainIncomplete, descV1ChainNotAfter,
+		descV1LegacyVerified, descV1Expectation, descV1DefaultCert, descV1DefaultCertNotAfter, descV1CertExpiresIn,
+	}
+	exporterDescs = []*prometheus.Desc{
+		descSchemaInfo, descSilenced,
+		descScannerPort, descScannerRangeProgress, descScannerPortsScanned, descScannerPortsTotal,
+		descScannerScanStart, descScannerScanFinish,
+		descInventoryCertEndpoints, descInventoryCertNotAfter, descInventoryCertEndpoint,
+		descInventoryDomainVariants, descInventoryDomainStraggler,
+		descWaitPoolInFlight, descWaitPoolQueued, descWaitPoolLimit,
+		descAutoDiscoverRecords, descAutoDiscoverLastSuccess, descAutoDiscoverRefreshFailed, descCollectDuration,
+		descV1ScannerPort, descV1CertEndpoints, descV1DomainCertVariants,
+	}
+}
+
+// sendGauge sends a gauge of the fixed descriptor, labelValues should be in the order of its label names.
+func sendGauge(ch chan<- prometheus.Metric, desc *prometheus.Desc, value float64, labelValues ...string) {
+	m, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, value, labelValues...)
+	if err != nil {
+		log.Warn().Msgf("exec NewConstMetric %s failed, error: %v", desc, err)
+		return
+	}
+	ch <- m
+}
+
+// ErrorClass maps a check error to one of the bounded error classes, it returns "" for nil.
+func ErrorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+	var (
+		netErr           net.Error
+		dnsErr           *net.DNSError
+		recordErr        tls.RecordHeaderError
+		alertErr         tls.AlertError
+		unknownAuthority x509.UnknownAuthorityError
+		hostnameErr      x509.HostnameError
+		invalidErr       x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(err, &dnsErr):
+		return ErrorClassDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorClassRefused
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorClassTimeout
+	case errors.As(err, &recordErr):
+		return ErrorClassNotTLS
+	case errors.Is(err, io.EOF), errors.Is(err, syscall.ECONNRESET):
+		return ErrorClassEOF
+	case errors.As(err, &unknownAuthority):
+		return ErrorClassUnknownAuthority
+	case errors.As(err, &hostnameErr):
+		return ErrorClassHostname
+	case errors.As(err, &invalidErr):
+		if invalidErr.Reason == x509.Expired {
+			return ErrorClassExpired
+		}
+		return ErrorClassInvalidCert
+	case errors.As(err, &alertErr), strings.Contains(err.Error(), "tls: "):
+		return ErrorClassHandshake
+	}
+	return ErrorClassOther
+}
+
+// endpointLabelValues returns the values of endpointLabelNames.
+func (t *TLSChecker) endpointLabelValues() []string {
+	return []string{t.Host, fmt.Sprintf("%d", t.Port), t.TLSCheckOptions.Domain}
+}
@@ -0,0 +1,135 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// checkersCollector collects the TLSCheckers with the descriptors of the schema.
+type checkersCollector []*TLSChecker
+
+func (c checkersCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range checkerDescs {
+		ch <- d
+	}
+}
+
+func (c checkersCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, checker := range c {
+		checker.CollectTLSStatus(ch)
+	}
+}
+
+func TestMetricSchema(t *testing.T) {
+	if err := prometheus.NewPedanticRegistry().Register(Exp); err != nil {
+		t.Fatalf("descriptors of the exporter are inconsistent: %v", err)
+	}
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	leaf := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com"}}, ca)
+	port := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{leaf.TLSCertificate()}})
+	ok := NewTLSChecker(nil, "127.0.0.1", port, TLSCheckOptions{Domain: "www.example.com", InsecureSkipVerify: true, DefaultCert: DefaultCertNoSNI})
+	ok.Expect = TLSExpectations{Issuers: []string{"CN=other ca"}}
+	// the test ca is not trusted, so the check failed.
+	failedPort := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{leaf.TLSCertificate()}})
+	failed := NewTLSChecker(nil, "127.0.0.1", failedPort, TLSCheckOptions{Domain: "www.example.com"})
+
+	for _, legacy := range []bool{false, true} {
+		Exp.SetLegacyMetrics(legacy)
+		registry := prometheus.NewPedanticRegistry()
+		if err := registry.Register(checkersCollector{ok, failed}); err != nil {
+			t.Fatal(err)
+		}
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatalf("legacy: %v, gather failed: %v", legacy, err)
+		}
+		names := make(map[string]struct{})
+		for _, f := range families {
+			names[f.GetName()] = struct{}{}
+		}
+		for _, name := range []string{"tlsprobe_check_success", "tlsprobe_check_error", "tlsprobe_cert_info",
+			"tlsprobe_cert_not_after_seconds", "tlsprobe_expectation_failed", "tlsprobe_default_cert_info",
+			"tlsprobe_cert_expires_in_seconds", "tlsprobe_cert_status"} {
+			if _, exists := names[name]; !exists {
+				t.Fatalf("legacy: %v, %s should be exported, but now is: %v", legacy, name, names)
+			}
+		}
+		for _, name := range []string{"tls_checker", "tls_cert_expires_in_seconds"} {
+			if _, exists := names[name]; exists != legacy {
+				t.Fatalf("%s should be exported only when legacy metrics is enabled, legacy: %v", name, legacy)
+			}
+		}
+	}
+	Exp.SetLegacyMetrics(false)
+}
+
+func TestMetricSchemaNames(t *testing.T) {
+	legacy := map[*prometheus.Desc]bool{
+		descV1Checker: true, descV1NotAfter: true, descV1NotBefore: true, descV1ChainIncomplete: true, descV1ChainNotAfter: true,
+		descV1LegacyVerified: true, descV1Expectation: true, descV1DefaultCert: true, descV1DefaultCertNotAfter: true, descV1ScannerPort: true,
+		descV1CertExpiresIn: true, descV1CertEndpoints: true, descV1DomainCertVariants: true,
+	}
+	descRegexp := regexp.MustCompile(`fqName: "([^"]+)".*variableLabels: \[([^\]]*)\]`)
+	snakeCase := regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	for _, d := range append(append([]*prometheus.Desc{descProbeSuccess, descProbeDuration}, checkerDescs...), exporterDescs...) {
+		if legacy[d] {
+			continue
+		}
+		match := descRegexp.FindStringSubmatch(d.String())
+		if match == nil {
+			t.Fatalf("unexpected descriptor: %s", d)
+		}
+		if !strings.HasPrefix(match[1], "tlsprobe_") {
+			t.Fatalf("%s should have the tlsprobe_ prefix", match[1])
+		}
+		// the endpoint labels are always in the order host, port, domain.
+		rank := map[string]int{"host": 1, "port": 2, "domain": 3}
+		last := 0
+		for _, label := range strings.Fields(match[2]) {
+			if !snakeCase.MatchString(label) {
+				t.Fatalf("label %s of %s should be snake_case", label, match[1])
+			}
+			if r, exists := rank[label]; exists {
+				if r < last {
+					t.Fatalf("endpoint labels of %s should be in the order host, port, domain, but now is: %s", match[1], match[2])
+				}
+				last = r
+			}
+		}
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	cases := []struct {
+		err   error
+		class string
+	}{
+		{nil, ""},
+		{fmt.Errorf("tls check error: %w", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}), ErrorClassRefused},
+		{fmt.Errorf("tlsChecker WithConn error: %w", context.DeadlineExceeded), ErrorClassTimeout},
+		{fmt.Errorf("tls check error: %w", &net.DNSError{Err: "no such host", IsNotFound: true}), ErrorClassDNS},
+		{fmt.Errorf("tlsChecker WithConn error: %w", tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}), ErrorClassNotTLS},
+		{fmt.Errorf("tlsChecker WithConn error: %w", io.EOF), ErrorClassEOF},
+		{fmt.Errorf("tlsChecker verify error: %w", x509.UnknownAuthorityError{}), ErrorClassUnknownAuthority},
+		{fmt.Errorf("tlsChecker verify error: %w", x509.HostnameError{Host: "www.example.com", Certificate: &x509.Certificate{}}), ErrorClassHostname},
+		{fmt.Errorf("tlsChecker verify error: %w", x509.CertificateInvalidError{Reason: x509.Expired}), ErrorClassExpired},
+		{errors.New("tlsChecker WithConn error: tls: handshake failure"), ErrorClassHandshake},
+		{errors.New("something else"), ErrorClassOther},
+	}
+	for _, c := range cases {
+		if class := ErrorClass(c.err); class != c.class {
+			t.Fatalf("%v: class should be %q, but now is: %q", c.err, c.class, class)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+	"tlsprobe/notifier"
+)
+
+const (
+	AlertNameCertificateExpiry = "CertificateExpiry"
+	AlertNameProbeFailed       = "ProbeFailed"
+)
+
+// notifyFlushInterval is the interval between the flushes of the pending alerts.
+const notifyFlushInterval = 30 * time.Second
+
+// NotifyManager evaluates the probe results of the TLSCheckers, and sends the new, changed,
+// repeated and resolved alerts to the sinks, for the teams without Alertmanager.
+type NotifyManager struct {
+	cfg    notifier.Config
+	sinks  []notifier.Sink
+	alerts map[string]*alertState
+	// failures is the consecutive failed probes of every TLSChecker.
+	failures   map[string]uint
+	cancelFunc context.CancelFunc
+	mux        sync.Mutex
+	// flushMux serializes the flushes, so an alert is never sent twice.
+	flushMux sync.Mutex
+}
+
+type alertState struct {
+	alert *notifier.Alert
+	// sent is the alert sent last time to every sink, keyed by the sink name.
+	sent map[string]sentAlert
+}
+
+type sentAlert struct {
+	status   string
+	severity string
+	at       time.Time
+}
+
+// due returns whether the alert should be sent to the sink.
+func (s *alertState) due(sink string, repeatInterval time.Duration, disableResolved bool) bool {
+	sent, exists := s.sent[sink]
+	a := s.alert
+	if a.Status == notifier.AlertStatusResolved {
+		// only the sinks which got the firing alert get the resolved one.
+		return !disableResolved && exists && sent.status == notifier.AlertStatusFiring
+	}
+	return !exists || a.Status != sent.status || a.Severity != sent.severity || time.Since(sent.at) >= repeatInterval
+}
+
+func NewNotifyManager() *NotifyManager {
+	return &NotifyManager{
+		alerts:   make(map[string]*alertState),
+		failures: make(map[string]uint),
+	}
+}
+
+// Update recreates the sinks when the config is changed, the states of the alerts are kept.
+func (m *NotifyManager) Update(ctx context.Context, cfg *notifier.Config) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.cfg.CompareConfig(cfg) {
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Error().Msgf("update notifiers failed, the sinks are not changed: %v", err)
+		return
+	}
+	sinks := make([]notifier.Sink, 0, len(cfg.Sinks))
+	for i := range cfg.Sinks {
+		sink, err := notifier.CreateSink(&cfg.Sinks[i])
+		if err != nil {
+			log.Error().Msgf("create notifier sink %s failed: %v", cfg.Sinks[i].Name, err)
+			continue
+		}
+		sinks = append(sinks, sink)
+	}
+	m.cfg = *cfg
+	m.sinks = sinks
+	log.Info().Msgf("updated notifiers: %d sinks", len(sinks))
+	if m.cancelFunc != nil {
+		m.cancelFunc()
+		m.cancelFunc = nil
+	}
+	if len(sinks) > 0 {
+		c, cf := context.WithCancel(ctx)
+		m.cancelFunc = cf
+		go m.run(c, cfg.GetProbeInterval())
+	}
+}
+
+// run flushes the pending alerts every notifyFlushInterval, it is the only caller of Flush besides the tests.
+// When probeInterval is set, the TLSCheckers not probed by a scrape within probeInterval are probed,
+// so the alerts are evaluated without scrapes.
+func (m *NotifyManager) run(ctx context.Context, probeInterval time.Duration) {
+	flushTicker := time.NewTicker(notifyFlushInterval)
+	defer flushTicker.Stop()
+	var probeC <-chan time.Time
+	if probeInterval > 0 {
+		probeTicker := time.NewTicker(probeInterval)
+		defer probeTicker.Stop()
+		probeC = probeTicker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-flushTicker.C:
+			m.Flush(ctx)
+		case <-probeC:
+			Exp.ProbeStale(probeInterval)
+			m.Flush(ctx)
+		}
+	}
+}
+
+func (m *NotifyManager) Enabled() bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return len(m.sinks) > 0
+}
+
+// Observe evaluates the probe result of the TLSChecker.
+// The expiry is evaluated from the peer certificate even though it failed to verify,
+// an expired certificate fires CertificateExpiry instead of ProbeFailed.
+func (m *NotifyManager) Observe(t *TLSChecker, stat *tls.ConnectionState, err error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if len(m.sinks) == 0 {
+		return
+	}
+	key := t.Key()
+	status := ""
+	var notAfter time.Time
+	if stat != nil && len(stat.PeerCertificates) > 0 {
+		notAfter = stat.PeerCertificates[0].NotAfter
+		status = t.ExpiryThresholds().Status(time.Until(notAfter))
+	}
+	if err != nil && status != CertStatusExpired {
+		m.failures[key]++
+		if m.failures[key] >= m.cfg.GetFailureThreshold() {
+			alert := newAlert(t, AlertNameProbeFailed, "warning")
+			alert.Error = err.Error()
+			alert.Summary = fmt.Sprintf("probe failed %d times: %s", m.failures[key], ErrorClass(err))
+			m.fire(alert)
+		}
+	} else {
+		delete(m.failures, key)
+		m.resolve(key + "/" + AlertNameProbeFailed)
+	}
+	if status == "" {
+		return
+	}
+	expiresIn := time.Until(notAfter)
+	if status == CertStatusOK {
+		m.resolve(key + "/" + AlertNameCertificateExpiry)
+		return
+	}
+	alert := newAlert(t, AlertNameCertificateExpiry, status)
+	alert.NotAfter = notAfter
+	if status == CertStatusExpired {
+		alert.Summary = fmt.Sprintf("certificate expired at %s", notAfter.Format(time.RFC3339))
+	} else {
+		alert.Summary = fmt.Sprintf("certificate expires in %d days at %s", int(expiresIn.Hours()/24), notAfter.Format(time.RFC3339))
+	}
+	m.fire(alert)
+}
+
+func newAlert(t *TLSChecker, name string, severity string) *notifier.Alert {
+	return &notifier.Alert{
+		Key:      t.Key() + "/" + name,
+		Name:     name,
+		Status:   notifier.AlertStatusFiring,
+		Severity: severity,
+		Host:     t.Host,
+		Port:     t.Port,
+		Domain:   t.TLSCheckOptions.Domain,
+	}
+}
+
+// fire updates the firing alert, StartsAt is kept while the alert keeps firing.
+func (m *NotifyManager) fire(alert *notifier.Alert) {
+	state, exists := m.alerts[alert.Key]
+	if !exists {
+		state = &alertState{sent: make(map[string]sentAlert)}
+		m.alerts[alert.Key] = state
+	}
+	if exists && state.alert.Status == notifier.AlertStatusFiring {
+		alert.StartsAt = state.alert.StartsAt
+	} else {
+		alert.StartsAt = time.Now()
+	}
+	state.alert = alert
+}
+
+func (m *NotifyManager) resolve(key string) {
+	state, exists := m.alerts[key]
+	if !exists || state.alert.Status == notifier.AlertStatusResolved {
+		return
+	}
+	resolved := *state.alert
+	resolved.Status = notifier.AlertStatusResolved
+	resolved.EndsAt = time.Now()
+	state.alert = &resolved
+}
+
+// Remove resolves the alerts of a removed TLSChecker.
+func (m *NotifyManager) Remove(key string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	delete(m.failures, key)
+	m.resolve(key + "/" + AlertNameProbeFailed)
+	m.resolve(key + "/" + AlertNameCertificateExpiry)
+}
+
+// sinkAlerts are the alerts which should be sent to a sink.
+type sinkAlerts struct {
+	sink   notifier.Sink
+	alerts []*notifier.Alert
+}
+
+// pending returns the alerts which should be sent to every sink,
+// the resolved alerts which need not be sent to any sink are dropped.
+func (m *NotifyManager) pending() []sinkAlerts {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	pending := make([]sinkAlerts, 0, len(m.sinks))
+	for _, sink := range m.sinks {
+		pending = append(pending, sinkAlerts{sink: sink})
+	}
+	repeatInterval := m.cfg.GetRepeatInterval()
+	for key, state := range m.alerts {
+		if state.alert.Status == notifier.AlertStatusResolved && !m.dueToAnySink(state) {
+			delete(m.alerts, key)
+			continue
+		}
+		for i := range pending {
+			if state.due(pending[i].sink.Name(), repeatInterval, m.cfg.DisableResolved) {
+				pending[i].alerts = append(pending[i].alerts, state.alert)
+			}
+		}
+	}
+	return pending
+}
+
+// Flush sends the pending alerts to every sink, the sent state is tracked per sink,
+// so the alerts failed to send to a sink are sent to it again by the next flush.
+func (m *NotifyManager) Flush(ctx context.Context) {
+	m.flushMux.Lock()
+	defer m.flushMux.Unlock()
+	for _, p := range m.pending() {
+		if len(p.alerts) == 0 {
+			continue
+		}
+		if err := p.sink.Send(ctx, &notifier.Notification{Alerts: p.alerts}); err != nil {
+			log.Error().Msgf("notifier sink %s send %d alerts failed: %v", p.sink.Name(), len(p.alerts), err)
+			continue
+		}
+		log.Info().Msgf("notifier sink %s sent %d alerts", p.sink.Name(), len(p.alerts))
+		m.markSent(p.sink.Name(), p.alerts)
+	}
+}
+
+func (m *NotifyManager) markSent(sink string, alerts []*notifier.Alert) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	now := time.Now()
+	for _, a := range alerts {
+		state, exists := m.alerts[a.Key]
+		if !exists {
+			continue
+		}
+		state.sent[sink] = sentAlert{status: a.Status, severity: a.Severity, at: now}
+		if state.alert.Status == notifier.AlertStatusResolved && !m.dueToAnySink(state) {
+			delete(m.alerts, a.Key)
+		}
+	}
+}
+
+func (m *NotifyManager) dueToAnySink(state *alertState) bool {
+	for _, sink := range m.sinks {
+		if state.due(sink.Name(), m.cfg.GetRepeatInterval(), m.cfg.DisableResolved) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+	"tlsprobe/notifier"
+)
+
+func TestNotifyManager(t *testing.T) {
+	var mux sync.Mutex
+	received := make([]*notifier.Notification, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := &notifier.Notification{}
+		if err := json.NewDecoder(r.Body).Decode(n); err != nil {
+			t.Errorf("decode notification failed: %v", err)
+		}
+		mux.Lock()
+		received = append(received, n)
+		mux.Unlock()
+	}))
+	defer server.Close()
+	// flush returns the alert names and statuses received by the flush.
+	flush := func(m *NotifyManager) []string {
+		mux.Lock()
+		before := len(received)
+		mux.Unlock()
+		m.Flush(context.Background())
+		mux.Lock()
+		defer mux.Unlock()
+		alerts := make([]string, 0)
+		for _, n := range received[before:] {
+			for _, a := range n.Alerts {
+				alerts = append(alerts, a.Name+"/"+a.Status+"/"+a.Severity)
+			}
+		}
+		sort.Strings(alerts)
+		return alerts
+	}
+
+	m := NewNotifyManager()
+	m.Update(context.Background(), &notifier.Config{
+		FailureThreshold: 2,
+		Sinks:            []notifier.SinkConfig{{Name: "test", Type: "webhook", URL: server.URL}},
+	})
+	checker := NewTLSChecker(nil, "127.0.0.1", 443, TLSCheckOptions{Domain: "www.example.com", WarningDays: 30, CriticalDays: 7})
+	expiring := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{NotAfter: time.Now().Add(10 * 24 * time.Hour)}}}
+	renewed := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{NotAfter: time.Now().Add(90 * 24 * time.Hour)}}}
+
+	m.Observe(checker, expiring, nil)
+	if alerts := flush(m); len(alerts) != 1 || alerts[0] != "CertificateExpiry/firing/warning" {
+		t.Fatalf("expiring certificate should fire a warning, but now is: %v", alerts)
+	}
+	m.Observe(checker, expiring, nil)
+	if alerts := flush(m); len(alerts) != 0 {
+		t.Fatalf("unchanged alert should not be sent again, but now is: %v", alerts)
+	}
+	m.mux.Lock()
+	state := m.alerts[checker.Key()+"/"+AlertNameCertificateExpiry]
+	sent := state.sent["test"]
+	sent.at = time.Now().Add(-5 * time.Hour)
+	state.sent["test"] = sent
+	m.mux.Unlock()
+	if alerts := flush(m); len(alerts) != 1 {
+		t.Fatalf("alert should be sent again after the repeat interval, but now is: %v", alerts)
+	}
+
+	probeErr := errors.New("tls check error: dial tcp 127.0.0.1:443: i/o timeout")
+	m.Observe(checker, nil, probeErr)
+	if alerts := flush(m); len(alerts) != 0 {
+		t.Fatalf("probe failure should not fire before the failure threshold, but now is: %v", alerts)
+	}
+	m.Observe(checker, nil, probeErr)
+	if alerts := flush(m); len(alerts) != 1 || alerts[0] != "ProbeFailed/firing/warning" {
+		t.Fatalf("probe failure should fire after the failure threshold, but now is: %v", alerts)
+	}
+
+	m.Observe(checker, renewed, nil)
+	expected := []string{"CertificateExpiry/resolved/warning", "ProbeFailed/resolved/warning"}
+	if alerts := flush(m); len(alerts) != 2 || alerts[0] != expected[0] || alerts[1] != expected[1] {
+		t.Fatalf("alerts should be resolved, but now is: %v", alerts)
+	}
+	if len(m.alerts) != 0 {
+		t.Fatalf("resolved alerts should be dropped after sent, but now is: %d", len(m.alerts))
+	}
+}
+
+func TestNotifyManagerExpiredVerifyError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	m := NewNotifyManager()
+	m.Update(context.Background(), &notifier.Config{Sinks: []notifier.SinkConfig{{Name: "test", Type: "webhook", URL: server.URL}}})
+	defer m.Update(context.Background(), &notifier.Config{})
+	checker := NewTLSChecker(nil, "127.0.0.1", 443, TLSCheckOptions{Domain: "www.example.com"})
+	expired := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{NotAfter: time.Now().Add(-time.Hour)}}}
+	verifyErr := fmt.Errorf("tlsChecker verify error: %w", x509.CertificateInvalidError{Reason: x509.Expired})
+
+	m.Observe(checker, expired, verifyErr)
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if _, exists := m.alerts[checker.Key()+"/"+AlertNameProbeFailed]; exists {
+		t.Fatal("expired certificate should not fire a probe failure")
+	}
+	state, exists := m.alerts[checker.Key()+"/"+AlertNameCertificateExpiry]
+	if !exists || state.alert.Severity != CertStatusExpired {
+		t.Fatalf("expired certificate should fire an expired alert, but now is: %+v", state)
+	}
+}
+
+func TestNotifyManagerExpiringVerifyError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	m := NewNotifyManager()
+	m.Update(context.Background(), &notifier.Config{Sinks: []notifier.SinkConfig{{Name: "test", Type: "webhook", URL: server.URL}}})
+	defer m.Update(context.Background(), &notifier.Config{})
+	checker := NewTLSChecker(nil, "127.0.0.1", 443, TLSCheckOptions{Domain: "www.example.com", WarningDays: 30, CriticalDays: 7})
+	expiring := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{NotAfter: time.Now().Add(3 * 24 * time.Hour)}}}
+	verifyErr := fmt.Errorf("tlsChecker verify error: %w", x509.UnknownAuthorityError{})
+
+	m.Observe(checker, expiring, verifyErr)
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if _, exists := m.alerts[checker.Key()+"/"+AlertNameProbeFailed]; !exists {
+		t.Fatal("the verify error should fire a probe failure")
+	}
+	state, exists := m.alerts[checker.Key()+"/"+AlertNameCertificateExpiry]
+	if !exists || state.alert.Severity != CertStatusCritical {
+		t.Fatalf("the certificate failed to verify should fire a critical expiry alert, but now is: %+v", state)
+	}
+}
+
+func TestNotifyManagerInvalidSinkNames(t *testing.T) {
+	m := NewNotifyManager()
+	for _, sinks := range [][]notifier.SinkConfig{
+		{{Type: "webhook", URL: "http://127.0.0.1/a"}},
+		{{Name: "test", Type: "webhook", URL: "http://127.0.0.1/a"}, {Name: "test", Type: "webhook", URL: "http://127.0.0.1/b"}},
+	} {
+		cfg := &notifier.Config{Sinks: sinks}
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("sinks %+v should be invalid", sinks)
+		}
+		m.Update(context.Background(), cfg)
+		if m.Enabled() {
+			t.Fatalf("the invalid sinks %+v should not be created", sinks)
+		}
+	}
+}
+
+func TestNotifyManagerRetryFailedSink(t *testing.T) {
+	var mux sync.Mutex
+	received := make(map[string]int)
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		if r.URL.Path == "/bad" && failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		received[r.URL.Path]++
+	}))
+	defer server.Close()
+	m := NewNotifyManager()
+	m.Update(context.Background(), &notifier.Config{Sinks: []notifier.SinkConfig{
+		{Name: "good", Type: "webhook", URL: server.URL + "/good"},
+		{Name: "bad", Type: "webhook", URL: server.URL + "/bad"},
+	}})
+	defer m.Update(context.Background(), &notifier.Config{})
+	checker := NewTLSChecker(nil, "127.0.0.1", 443, TLSCheckOptions{Domain: "www.example.com", WarningDays: 30, CriticalDays: 7})
+	expiring := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{NotAfter: time.Now().Add(10 * 24 * time.Hour)}}}
+
+	m.Observe(checker, expiring, nil)
+	m.Flush(context.Background())
+	mux.Lock()
+	failing = false
+	mux.Unlock()
+	m.Flush(context.Background())
+	mux.Lock()
+	defer mux.Unlock()
+	if received["/good"] != 1 || received["/bad"] != 1 {
+		t.Fatalf("the failed sink should be retried alone, but now is: %v", received)
+	}
+}
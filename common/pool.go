@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"reflect"
 	"sync"
+	"sync/atomic"
 )
 
 func wrapper(w *WaitPool, f interface{}, args ...interface{}) {
 	defer func() { <-w.ch }()
 	defer w.wg.Done()
+	defer atomic.AddInt64(&w.inFlight, -1)
 	fn := reflect.ValueOf(f)
 	if fn.Type().NumIn() != len(args) {
 		panic(fmt.Sprintf("invaild input parameters of function %v", fn.Type()))
@@ -24,6 +26,10 @@ type WaitPool struct {
 	limit uint
 	ch    chan struct{}
 	wg    *sync.WaitGroup
+	// inFlight is the count of the running functions, and queued is the count
+	// of the functions waiting for a free slot.
+	inFlight int64
+	queued   int64
 }
 
 func NewWaitPool(limit uint) *WaitPool {
@@ -36,10 +42,22 @@ func NewWaitPool(limit uint) *WaitPool {
 
 func (w *WaitPool) Run(f interface{}, args ...interface{}) {
 	w.wg.Add(1)
+	atomic.AddInt64(&w.queued, 1)
 	w.ch <- struct{}{}
+	atomic.AddInt64(&w.queued, -1)
+	atomic.AddInt64(&w.inFlight, 1)
 	go wrapper(w, f, args...)
 }
 
+// Stats returns the count of the running and the queued functions.
+func (w *WaitPool) Stats() (inFlight int64, queued int64) {
+	return atomic.LoadInt64(&w.inFlight), atomic.LoadInt64(&w.queued)
+}
+
+func (w *WaitPool) Limit() uint {
+	return w.limit
+}
+
 func (w *WaitPool) Wait() {
 	w.wg.Wait()
 }
@@ -0,0 +1,37 @@
+package common
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestWaitPoolStats(t *testing.T) {
+	w := NewWaitPool(1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	w.Run(func() {
+		close(started)
+		<-release
+	})
+	<-started
+	queuedDone := new(sync.WaitGroup)
+	queuedDone.Add(1)
+	go func() {
+		defer queuedDone.Done()
+		w.Run(func() {})
+	}()
+	for {
+		if _, queued := w.Stats(); queued == 1 {
+			break
+		}
+	}
+	if inFlight, _ := w.Stats(); inFlight != 1 {
+		t.Fatalf("in-flight should be 1, but now is: %d", inFlight)
+	}
+	close(release)
+	queuedDone.Wait()
+	w.Wait()
+	if inFlight, queued := w.Stats(); inFlight != 0 || queued != 0 {
+		t.Fatalf("pool should be idle, but now in-flight: %d, queued: %d", inFlight, queued)
+	}
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	cases := []struct {
+		spec  string
+		ports []uint
+		err   bool
+	}{
+		{"443", []uint{443}, false},
+		{"8443, 443,9000-9002,443", []uint{443, 8443, 9000, 9001, 9002}, false},
+		{"mail", []uint{25, 110, 143, 465, 587, 993, 995, 2525, 4190}, false},
+		{"", []uint{}, false},
+		{"9100-9000", nil, true},
+		{"0", nil, true},
+		{"65536", nil, true},
+		{"https", nil, true},
+	}
+	for _, c := range cases {
+		ports, err := ParsePorts(c.spec)
+		if (err != nil) != c.err {
+			t.Fatalf("%q: error should be %v, but now is: %v", c.spec, c.err, err)
+		}
+		if !c.err && !reflect.DeepEqual(ports, c.ports) {
+			t.Fatalf("%q: ports should be %v, but now is: %v", c.spec, c.ports, ports)
+		}
+	}
+
+	for name := range PortSets {
+		ports, err := ParsePorts(name)
+		if err != nil {
+			t.Fatalf("port set %s is invalid: %v", name, err)
+		}
+		if name == "top-1000" && len(ports) != 1000 {
+			t.Fatalf("top-1000 should have 1000 ports, but now is: %d", len(ports))
+		}
+	}
+}
+
+func TestHostScannerConfigScanPorts(t *testing.T) {
+	cfg := HostScannerConfig{Host: "127.0.0.1"}
+	ports, err := cfg.ScanPorts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 65526 || ports[0] != 10 {
+		t.Fatalf("default ports should be 10-65535, but now is: %d ports from %d", len(ports), ports[0])
+	}
+
+	cfg.Ports = "web,9000-9003"
+	cfg.ExcludePorts = "80,8000-8088,9001"
+	ports, err = cfg.ScanPorts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint{443, 4443, 8443, 8888, 9000, 9002, 9003, 9443}
+	if !reflect.DeepEqual(ports, expected) {
+		t.Fatalf("ports should be %v, but now is: %v", expected, ports)
+	}
+}
@@ -0,0 +1,81 @@
+package common
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// probeCreator owns the TLSCheckers created by the /probe handler.
+type probeCreator struct{}
+
+func (p probeCreator) Describe() string {
+	return "Probe Handler"
+}
+
+// probeCollector collects the metrics of a single TLSChecker.
+type probeCollector struct {
+	checker *TLSChecker
+}
+
+func (c *probeCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range checkerDescs {
+		ch <- d
+	}
+	ch <- descProbeSuccess
+	ch <- descProbeDuration
+}
+
+func (c *probeCollector) Collect(ch chan<- prometheus.Metric) {
+	startTime := time.Now()
+	_, err := c.checker.CollectTLSStatus(ch)
+	var success float64 = 0
+	if err == nil {
+		success = 1
+	}
+	sendGauge(ch, descProbeSuccess, success, c.checker.endpointLabelValues()...)
+	sendGauge(ch, descProbeDuration, time.Since(startTime).Seconds(), c.checker.endpointLabelValues()...)
+}
+
+// ProbeHandler runs a TLSChecker on demand and returns the metrics of that target only,
+// like blackbox_exporter, e.g. /probe?target=12.34.45.78:443&module=default&domain=abc.example.com.
+// The options come from the named module, the domain defaults to the target host.
+func ProbeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	host, portStr, err := net.SplitHostPort(query.Get("target"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid target %q: %v", query.Get("target"), err), http.StatusBadRequest)
+		return
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid target port %q: %v", portStr, err), http.StatusBadRequest)
+		return
+	}
+	var options TLSCheckOptions
+	if name := query.Get("module"); name != "" {
+		module, exists := Exp.GetModule(name)
+		if !exists {
+			http.Error(w, fmt.Sprintf("unknown module %q", name), http.StatusBadRequest)
+			return
+		}
+		options = module
+	}
+	if domain := query.Get("domain"); domain != "" {
+		options.Domain = domain
+	}
+	checker := NewTLSChecker(probeCreator{}, host, uint(port), options)
+	log.Debug().Msgf("probe target: %s, module: %s", checker.Key(), query.Get("module"))
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(&probeCollector{checker: checker}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
@@ -0,0 +1,44 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProbeHandler(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	leaf := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com"}}, ca)
+	port := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{leaf.TLSCertificate()}})
+	Exp.SetModules(map[string]TLSCheckOptions{
+		"insecure": {InsecureSkipVerify: true, Timeout: 1000},
+	})
+	defer Exp.SetModules(nil)
+
+	cases := []struct {
+		query    string
+		code     int
+		contains string
+	}{
+		{fmt.Sprintf("target=127.0.0.1:%d&module=insecure&domain=www.example.com", port), http.StatusOK, `tlsprobe_probe_success{domain="www.example.com",host="127.0.0.1",port="` + fmt.Sprint(port) + `"} 1`},
+		// the test ca is not trusted without the module.
+		{fmt.Sprintf("target=127.0.0.1:%d&domain=www.example.com", port), http.StatusOK, `tlsprobe_probe_success{domain="www.example.com",host="127.0.0.1",port="` + fmt.Sprint(port) + `"} 0`},
+		{fmt.Sprintf("target=127.0.0.1:%d&module=notexists", port), http.StatusBadRequest, "unknown module"},
+		{"target=127.0.0.1", http.StatusBadRequest, "invalid target"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		ProbeHandler(w, httptest.NewRequest(http.MethodGet, "/probe?"+c.query, nil))
+		if w.Code != c.code {
+			t.Fatalf("%s: status code should be %d, but now is: %d", c.query, c.code, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), c.contains) {
+			t.Fatalf("%s: body should contain %s, but now is: %s", c.query, c.contains, w.Body.String())
+		}
+	}
+}
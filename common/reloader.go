@@ -118,12 +118,40 @@ func (r *Reloader) LoadConfig(filename string) error {
 		log.Error().Err(err).Msg("")
 		return err
 	}
+	// the label names are checked before anything is changed, so a bad config is rejected as a whole.
+	if err := Exp.SetMetricLabelNames(CollectLabelNames(&cfg)); err != nil {
+		log.Error().Msgf("load config %s failed: %v", filename, err)
+		return err
+	}
+	if err := cfg.Notifiers.Validate(); err != nil {
+		log.Error().Msgf("load config %s failed: %v", filename, err)
+		return err
+	}
+	changedModules := Exp.SetModules(cfg.Modules)
+	Exp.SetLegacyMetrics(cfg.LegacyMetrics)
+	Exp.SetExpiryDefaults(cfg.ExpiryThresholds)
+	Exp.Notifier.Update(r.ctx, &cfg.Notifiers)
+	Exp.Reporter.Update(r.ctx, &cfg.Report)
+	Exp.SetOwnershipRules(cfg.Ownership)
+	Exp.Silences.Update(r.ctx, cfg.Silences)
+	Exp.Admin.Update(r.ctx, cfg.AdminToken)
+
+	// restore the state before any hostScanner or autoDiscover is created, only on the first load.
+	if cfg.StateFile != "" && !State.Enabled() {
+		if err := State.Load(cfg.StateFile); err != nil {
+			log.Error().Msgf("load state file %s failed: %v", cfg.StateFile, err)
+		}
+		Exp.Admin.Restore(State.AdminObjects())
+		go State.Run(r.ctx, cfg.GetStateSaveInterval())
+	}
+
 	//TODO(@xiaoshuo) should to handle already added collect's config changed and compare config.
 	// if collect's config has been changed reload it.
 
 	// hostScanner reload
 	hostScannerNamesMap := make(map[string]struct{})
-	for _, s := range cfg.HostScannersConfig {
+	for i := range cfg.HostScannersConfig {
+		s := cfg.HostScannersConfig[i]
 		hostScannerNamesMap[s.Key()] = struct{}{}
 		Exp.UpdateHostScannerConfig(r.ctx, &s, r)
 	}
@@ -131,9 +159,10 @@ func (r *Reloader) LoadConfig(filename string) error {
 	shouldDeleteHostScannersList := make([]string, 0)
 	Exp.HostScannerRWMutex.RLock()
 	for _, hs := range Exp.HostScanners {
-		_, exists := hostScannerNamesMap[hs.Config.Key()]
+		// the hostScanners are keyed by the config before the module is resolved.
+		_, exists := hostScannerNamesMap[hs.RawConfig.Key()]
 		if !exists && hs.Creator == r {
-			shouldDeleteHostScannersList = append(shouldDeleteHostScannersList, hs.Config.Key())
+			shouldDeleteHostScannersList = append(shouldDeleteHostScannersList, hs.RawConfig.Key())
 		}
 	}
 	Exp.HostScannerRWMutex.RUnlock()
@@ -143,7 +172,8 @@ func (r *Reloader) LoadConfig(filename string) error {
 
 	// update autoDiscover.
 	autoDiscoverNamesMap := make(map[string]struct{})
-	for _, a := range cfg.AutoDiscover {
+	for i := range cfg.AutoDiscover {
+		a := cfg.AutoDiscover[i]
 		autoDiscoverNamesMap[a.Name] = struct{}{}
 		Exp.UpdateAutoDiscover(r.ctx, &a, r)
 	}
@@ -165,6 +195,7 @@ func (r *Reloader) LoadConfig(filename string) error {
 	tlsCheckersNamesMap := make(map[string]struct{})
 	for i, _ := range cfg.TLSCheckers {
 		t := cfg.TLSCheckers[i]
+		Exp.ResolveTLSChecker(&t)
 		tlsCheckersNamesMap[t.Key()] = struct{}{}
 		Exp.UpdateTLSChecker(r.ctx, &t, r)
 	}
@@ -182,6 +213,9 @@ func (r *Reloader) LoadConfig(filename string) error {
 		Exp.RemoveTLSChecker(key)
 	}
 
+	// reload the hostScanners created by autoDiscover which reference the changed modules.
+	Exp.ReloadModuleDependents(changedModules)
+
 	r.Config = &cfg
 	return nil
 }
@@ -0,0 +1,284 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"tlsprobe/notifier"
+)
+
+// ReportConfig is the weekly email digest of the certificates which are expiring soon.
+type ReportConfig struct {
+	// Weekday is the day to send the digest like "Monday", default is Monday.
+	Weekday string `yaml:"weekday"`
+	// At is the local time to send the digest like "09:00", default is 09:00.
+	At string `yaml:"at"`
+	// Windows are the days of the expiry windows, default is 30, 60 and 90.
+	Windows []uint `yaml:"windows"`
+	// Subject default is "TLSProbe certificate expiry report".
+	Subject string              `yaml:"subject"`
+	SMTP    notifier.SMTPConfig `yaml:"smtp"`
+}
+
+func (r *ReportConfig) GetWindows() []uint {
+	windows := r.Windows
+	if len(windows) == 0 {
+		windows = []uint{30, 60, 90}
+	}
+	sorted := append([]uint{}, windows...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+func (r *ReportConfig) GetSubject() string {
+	if r.Subject == "" {
+		return "TLSProbe certificate expiry report"
+	}
+	return r.Subject
+}
+
+// NextRun returns the first scheduled time after now.
+func (r *ReportConfig) NextRun(now time.Time) (time.Time, error) {
+	weekday := time.Monday
+	if r.Weekday != "" {
+		found := false
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if strings.EqualFold(d.String(), r.Weekday) {
+				weekday, found = d, true
+				break
+			}
+		}
+		if !found {
+			return time.Time{}, fmt.Errorf("invalid report weekday %q", r.Weekday)
+		}
+	}
+	at := r.At
+	if at == "" {
+		at = "09:00"
+	}
+	clock, err := time.Parse("15:04", at)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid report time %q: %w", at, err)
+	}
+	next := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	next = next.AddDate(0, 0, (int(weekday)-int(next.Weekday())+7)%7)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next, nil
+}
+
+// Digest is the certificates grouped by expiry window, owner and domain.
+type Digest struct {
+	GeneratedAt time.Time
+	Windows     []*DigestWindow
+}
+
+// DigestWindow is the certificates expiring within Days but after the previous window,
+// Days is 0 for the expired certificates.
+type DigestWindow struct {
+	Days   uint
+	Owners []*DigestOwner
+}
+
+type DigestOwner struct {
+	Owner   string
+	Domains []*DigestDomain
+}
+
+type DigestDomain struct {
+	Domain       string
+	Certificates []*DigestCertificate
+}
+
+type DigestCertificate struct {
+	Fingerprint string
+	Subject     string
+	Issuer      string
+	NotAfter    time.Time
+	DaysLeft    int
+	// Endpoints are host:port of the endpoints serving the certificate for the domain.
+	Endpoints []string
+}
+
+// Empty returns true when no certificate is expiring.
+func (d *Digest) Empty() bool {
+	return len(d.Windows) == 0
+}
+
+// BuildDigest groups the certificates expiring within the largest window, an endpoint without an owner is grouped to "unknown".
+func BuildDigest(certs []*InventoryCertificate, windows []uint, now time.Time) *Digest {
+	type groupKey struct {
+		window uint
+		owner  string
+		domain string
+	}
+	groups := make(map[groupKey]map[string]*DigestCertificate)
+	for _, c := range certs {
+		left := c.Certificate.NotAfter.Sub(now)
+		window, matched := uint(0), left <= 0
+		for _, w := range windows {
+			if matched {
+				break
+			}
+			if left <= time.Duration(w)*24*time.Hour {
+				window, matched = w, true
+			}
+		}
+		if !matched {
+			continue
+		}
+		for _, e := range c.Endpoints {
+			owner := e.Owner
+			if owner == "" {
+				owner = "unknown"
+			}
+			key := groupKey{window, owner, e.Domain}
+			if groups[key] == nil {
+				groups[key] = make(map[string]*DigestCertificate)
+			}
+			dc, exists := groups[key][c.Fingerprint]
+			if !exists {
+				dc = &DigestCertificate{
+					Fingerprint: c.Fingerprint,
+					Subject:     c.Certificate.Subject.String(),
+					Issuer:      c.Certificate.Issuer.String(),
+					NotAfter:    c.Certificate.NotAfter,
+					DaysLeft:    int(left.Hours() / 24),
+				}
+				groups[key][c.Fingerprint] = dc
+			}
+			dc.Endpoints = append(dc.Endpoints, fmt.Sprintf("%s:%d", e.Host, e.Port))
+		}
+	}
+
+	digest := &Digest{GeneratedAt: now, Windows: make([]*DigestWindow, 0)}
+	keys := make([]groupKey, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].window != keys[j].window {
+			return keys[i].window < keys[j].window
+		}
+		if keys[i].owner != keys[j].owner {
+			return keys[i].owner < keys[j].owner
+		}
+		return keys[i].domain < keys[j].domain
+	})
+	for _, key := range keys {
+		if n := len(digest.Windows); n == 0 || digest.Windows[n-1].Days != key.window {
+			digest.Windows = append(digest.Windows, &DigestWindow{Days: key.window})
+		}
+		window := digest.Windows[len(digest.Windows)-1]
+		if n := len(window.Owners); n == 0 || window.Owners[n-1].Owner != key.owner {
+			window.Owners = append(window.Owners, &DigestOwner{Owner: key.owner})
+		}
+		owner := window.Owners[len(window.Owners)-1]
+		domain := &DigestDomain{Domain: key.domain}
+		for _, dc := range groups[key] {
+			sort.Strings(dc.Endpoints)
+			domain.Certificates = append(domain.Certificates, dc)
+		}
+		sort.Slice(domain.Certificates, func(i, j int) bool {
+			return domain.Certificates[i].NotAfter.Before(domain.Certificates[j].NotAfter)
+		})
+		owner.Domains = append(owner.Domains, domain)
+	}
+	return digest
+}
+
+var digestTemplate = template.Must(template.New("digest").Parse(`TLSProbe certificate expiry report, generated at {{.GeneratedAt.Format "2006-01-02 15:04 MST"}}.
+{{if .Empty}}
+No certificate is expiring.
+{{end}}{{range .Windows}}
+{{if eq .Days 0}}== Expired =={{else}}== Expiring in {{.Days}} days =={{end}}
+{{range .Owners}}
+Owner: {{.Owner}}
+{{range .Domains}}  Domain: {{.Domain}}
+{{range .Certificates}}    - {{.Subject}}, issued by {{.Issuer}}
+      NotAfter: {{.NotAfter.Format "2006-01-02 15:04 MST"}} ({{.DaysLeft}} days left), fingerprint: {{.Fingerprint}}
+      Endpoints: {{range $i, $e := .Endpoints}}{{if $i}}, {{end}}{{$e}}{{end}}
+{{end}}{{end}}{{end}}{{end}}`))
+
+// Render returns the plain text of the digest.
+func (d *Digest) Render() (string, error) {
+	buf := new(bytes.Buffer)
+	if err := digestTemplate.Execute(buf, d); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Reporter sends the digest on schedule, it is disabled when the SMTP server is not configured.
+type Reporter struct {
+	cfg        ReportConfig
+	cancelFunc context.CancelFunc
+	mux        sync.Mutex
+}
+
+func NewReporter() *Reporter {
+	return &Reporter{}
+}
+
+// Update restarts the schedule when the config is changed.
+func (r *Reporter) Update(ctx context.Context, cfg *ReportConfig) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if reflect.DeepEqual(&r.cfg, cfg) {
+		return
+	}
+	if r.cancelFunc != nil {
+		r.cancelFunc()
+		r.cancelFunc = nil
+	}
+	r.cfg = *cfg
+	if !cfg.SMTP.Enabled() {
+		return
+	}
+	if _, err := cfg.NextRun(time.Now()); err != nil {
+		log.Error().Msgf("report disabled: %v", err)
+		return
+	}
+	c, cf := context.WithCancel(ctx)
+	r.cancelFunc = cf
+	go r.run(c, *cfg)
+}
+
+func (r *Reporter) run(ctx context.Context, cfg ReportConfig) {
+	for {
+		next, _ := cfg.NextRun(time.Now())
+		log.Info().Msgf("next expiry report at %s", next)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := SendReport(&cfg, time.Now()); err != nil {
+			log.Error().Msgf("send expiry report failed: %v", err)
+		}
+	}
+}
+
+// SendReport builds the digest from the certificate inventory and sends it.
+func SendReport(cfg *ReportConfig, now time.Time) error {
+	digest := BuildDigest(Exp.Inventory.Certificates(), cfg.GetWindows(), now)
+	body, err := digest.Render()
+	if err != nil {
+		return err
+	}
+	if err := notifier.SendMail(&cfg.SMTP, cfg.GetSubject(), body); err != nil {
+		return err
+	}
+	log.Info().Msgf("sent expiry report to %v", cfg.SMTP.To)
+	return nil
+}
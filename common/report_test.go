@@ -0,0 +1,85 @@
+package common
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildDigest(t *testing.T) {
+	now := time.Date(2023, 5, 8, 9, 0, 0, 0, time.UTC)
+	cert := func(cn string, days int) *x509.Certificate {
+		return &x509.Certificate{Subject: pkix.Name{CommonName: cn}, NotAfter: now.Add(time.Duration(days) * 24 * time.Hour)}
+	}
+	certs := []*InventoryCertificate{
+		{Fingerprint: "expired", Certificate: cert("old.example.com", -1), Endpoints: []*InventoryEndpoint{
+			{Host: "10.0.0.1", Port: 443, Domain: "old.example.com"},
+		}},
+		{Fingerprint: "soon", Certificate: cert("*.example.com", 10), Endpoints: []*InventoryEndpoint{
+			{Host: "10.0.0.2", Port: 443, Domain: "www.example.com", Owner: "web"},
+			{Host: "10.0.0.1", Port: 443, Domain: "www.example.com", Owner: "web"},
+			{Host: "10.0.0.3", Port: 8443, Domain: "pay.example.com", Owner: "pay"},
+		}},
+		{Fingerprint: "later", Certificate: cert("api.example.com", 75), Endpoints: []*InventoryEndpoint{
+			{Host: "10.0.0.4", Port: 443, Domain: "api.example.com", Owner: "web"},
+		}},
+		{Fingerprint: "fine", Certificate: cert("fine.example.com", 200), Endpoints: []*InventoryEndpoint{
+			{Host: "10.0.0.5", Port: 443, Domain: "fine.example.com", Owner: "web"},
+		}},
+	}
+	digest := BuildDigest(certs, []uint{30, 60, 90}, now)
+	if len(digest.Windows) != 3 || digest.Windows[0].Days != 0 || digest.Windows[1].Days != 30 || digest.Windows[2].Days != 90 {
+		t.Fatalf("windows should be expired, 30 and 90 days, but now is: %+v", digest.Windows)
+	}
+	soon := digest.Windows[1]
+	if len(soon.Owners) != 2 || soon.Owners[0].Owner != "pay" || soon.Owners[1].Owner != "web" {
+		t.Fatalf("30 days window should be grouped by owner pay and web, but now is: %+v", soon.Owners)
+	}
+	web := soon.Owners[1].Domains[0]
+	if web.Domain != "www.example.com" || strings.Join(web.Certificates[0].Endpoints, ",") != "10.0.0.1:443,10.0.0.2:443" {
+		t.Fatalf("www.example.com should be served by 2 endpoints, but now is: %+v", web.Certificates[0])
+	}
+	if owner := digest.Windows[0].Owners[0].Owner; owner != "unknown" {
+		t.Fatalf("endpoint without owner should be grouped to unknown, but now is: %s", owner)
+	}
+
+	text, err := digest.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"== Expired ==", "== Expiring in 30 days ==", "Owner: pay", "Domain: www.example.com", "(10 days left)", "Endpoints: 10.0.0.1:443, 10.0.0.2:443"} {
+		if !strings.Contains(text, expected) {
+			t.Fatalf("report should contain %q, but now is:\n%s", expected, text)
+		}
+	}
+	if strings.Contains(text, "fine.example.com") {
+		t.Fatalf("certificate out of the windows should not be reported:\n%s", text)
+	}
+}
+
+func TestReportNextRun(t *testing.T) {
+	// 2023-05-08 is a Monday.
+	now := time.Date(2023, 5, 8, 10, 0, 0, 0, time.UTC)
+	cases := []struct {
+		cfg  ReportConfig
+		next time.Time
+	}{
+		{ReportConfig{}, time.Date(2023, 5, 15, 9, 0, 0, 0, time.UTC)},
+		{ReportConfig{At: "18:30"}, time.Date(2023, 5, 8, 18, 30, 0, 0, time.UTC)},
+		{ReportConfig{Weekday: "friday"}, time.Date(2023, 5, 12, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		next, err := c.cfg.NextRun(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !next.Equal(c.next) {
+			t.Fatalf("%+v: next run should be %s, but now is: %s", c.cfg, c.next, next)
+		}
+	}
+	if _, err := (&ReportConfig{Weekday: "someday"}).NextRun(now); err == nil {
+		t.Fatal("invalid weekday should fail")
+	}
+}
@@ -0,0 +1,141 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// scanConfigChecker is an item of TLSCheckers written by the scan command, the unset options are omitted.
+type scanConfigChecker struct {
+	Host            string `yaml:"host"`
+	Port            uint   `yaml:"port"`
+	TLSCheckOptions struct {
+		Domain             string `yaml:"domain,omitempty"`
+		InsecureSkipVerify bool   `yaml:"skipVerify,omitempty"`
+		CAFile             string `yaml:"caFile,omitempty"`
+	} `yaml:"TLSCheckOptions,omitempty"`
+}
+
+// ScanConfigSnippet returns the config of TLSCheckers of the found checkers, it is ready to paste into config.yml.
+func ScanConfigSnippet(checkers []*TLSChecker) ([]byte, error) {
+	items := make([]scanConfigChecker, 0, len(checkers))
+	for _, c := range checkers {
+		item := scanConfigChecker{Host: c.Host, Port: c.Port}
+		if c.TLSCheckOptions.Domain != c.Host {
+			item.TLSCheckOptions.Domain = c.TLSCheckOptions.Domain
+		}
+		item.TLSCheckOptions.InsecureSkipVerify = c.InsecureSkipVerify
+		item.TLSCheckOptions.CAFile = c.CAFile
+		items = append(items, item)
+	}
+	return yaml.Marshal(map[string][]scanConfigChecker{"TLSCheckers": items})
+}
+
+// formatScanFound describes a TLS port found by the scan command in one line, err is the verify error.
+func formatScanFound(checker *TLSChecker, stat *tls.ConnectionState, err error) string {
+	found := fmt.Sprintf("found %s domain %s", checker.Addr(), checker.TLSCheckOptions.Domain)
+	if stat == nil || len(stat.PeerCertificates) == 0 {
+		return fmt.Sprintf("%s: %v", found, err)
+	}
+	cert := stat.PeerCertificates[0]
+	days := int(math.Floor(time.Until(cert.NotAfter).Hours() / 24))
+	verification := VerificationVerified
+	switch {
+	case checker.InsecureSkipVerify:
+		verification = VerificationSkipped
+	case err != nil:
+		verification = fmt.Sprintf("%s (%v)", VerificationFailed, err)
+	}
+	return fmt.Sprintf("%s: %s, subject %q, issuer %q, NotAfter %s (%d days), %s, dns names %v",
+		found, tls.VersionName(stat.Version), cert.Subject.String(), cert.Issuer.String(),
+		cert.NotAfter.Format(time.RFC3339), days, verification, cert.DNSNames)
+}
+
+// RunScanCommand runs "tlsprobe scan [flags] host|cidr...", it scans the targets once without the exporter
+// and prints the TLS ports as they are found. It returns 0 on success, 1 when a scan failed and 2 when the usage is invalid.
+func RunScanCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := pflag.NewFlagSet("scan", pflag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: tlsprobe scan [flags] host|cidr...")
+		flags.PrintDefaults()
+	}
+	var config HostScannerConfig
+	flags.StringVarP(&config.Ports, "ports", "p", "", fmt.Sprintf("port spec to scan like \"web,443,9000-9100\", default is %s.", DefaultScanPorts))
+	flags.StringVar(&config.ExcludePorts, "exclude-ports", "", "port spec which should not be scanned.")
+	flags.StringVar(&config.TLSOptions.Domain, "domain", "", "SNI of the handshakes, default is the scanned host.")
+	flags.UintVar(&config.TLSOptions.Timeout, "timeout", 3000, "timeout in milliseconds.")
+	flags.UintVar(&config.TLSOptions.ReTryTimes, "retry", 0, "retry times of the timed out connections.")
+	flags.BoolVar(&config.TLSOptions.InsecureSkipVerify, "skip-verify", false, "do not verify the certificates.")
+	flags.StringVar(&config.TLSOptions.CAFile, "ca-file", "", "PEM bundle of the roots, the system roots are used when it is empty.")
+	flags.UintVar(&config.RateLimit, "rate-limit", 0, "new connections per second of a CIDR scan, 0 means no limit.")
+	concurrency := flags.UintP("concurrency", "n", 100, "concurrent connections.")
+	writeConfig := flags.String("write-config", "", "write the found ports as TLSCheckers config to the file, \"-\" is stdout.")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 || *concurrency == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	var mux sync.Mutex
+	found := make([]*TLSChecker, 0)
+	onFound := func(checker *TLSChecker, stat *tls.ConnectionState, err error) {
+		// the hostScanner only handshakes, the verify error does not stop printing the certificate.
+		if err == nil && !checker.InsecureSkipVerify {
+			stat.VerifiedChains, err = VerifyCertificates(stat.PeerCertificates, checker.TLSCheckOptions.Domain, checker.CAFile, checker.GetTimeout())
+		}
+		mux.Lock()
+		defer mux.Unlock()
+		found = append(found, checker)
+		fmt.Fprintln(stdout, formatScanFound(checker, stat, err))
+	}
+
+	code := 0
+	wa := NewWaitPool(*concurrency)
+	for _, target := range flags.Args() {
+		cfg := config
+		cfg.Host = target
+		scanner := NewHostScanner(nil, &cfg, &cfg, wa, context.Background())
+		scanner.onFound = onFound
+		if err := scanner.ScanOnce(); err != nil {
+			fmt.Fprintf(stderr, "scan %s failed: %v\n", target, err)
+			code = 1
+		}
+	}
+	sort.Slice(found, func(a, b int) bool {
+		if found[a].Host != found[b].Host {
+			return found[a].Host < found[b].Host
+		}
+		return found[a].Port < found[b].Port
+	})
+	fmt.Fprintf(stderr, "found %d TLS ports\n", len(found))
+
+	if *writeConfig == "" {
+		return code
+	}
+	snippet, err := ScanConfigSnippet(found)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if *writeConfig == "-" {
+		stdout.Write(snippet)
+		return code
+	}
+	if err := os.WriteFile(*writeConfig, snippet, 0644); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return code
+}
@@ -0,0 +1,86 @@
+package common
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunScanCommand(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	leaf := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "www.example.com"}, DNSNames: []string{"www.example.com"}}, ca)
+	port := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{leaf.TLSCertificate()}})
+	// a plain TCP port is not a TLS port.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("SSH-2.0-OpenSSH\r\n"))
+			conn.Close()
+		}
+	}()
+	plainPort := ln.Addr().(*net.TCPAddr).Port
+
+	filename := filepath.Join(t.TempDir(), "checkers.yml")
+	var stdout, stderr bytes.Buffer
+	code := RunScanCommand([]string{"--ports", fmt.Sprintf("%d,%d", port, plainPort), "--domain", "www.example.com",
+		"--write-config", filename, "127.0.0.1/32"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("exit code %d, stderr: %s", code, stderr.String())
+	}
+	out := stdout.String()
+	if strings.Count(out, "found ") != 1 || !strings.Contains(out, fmt.Sprintf("found 127.0.0.1:%d domain www.example.com", port)) ||
+		!strings.Contains(out, "CN=www.example.com") || !strings.Contains(out, VerificationFailed) {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+	if !strings.Contains(stderr.String(), "found 1 TLS ports") {
+		t.Fatalf("unexpected stderr:\n%s", stderr.String())
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.TLSCheckers) != 1 {
+		t.Fatalf("unexpected config:\n%s", data)
+	}
+	c := cfg.TLSCheckers[0]
+	if c.Host != "127.0.0.1" || c.Port != port || c.TLSCheckOptions.Domain != "www.example.com" || c.InsecureSkipVerify {
+		t.Fatalf("unexpected config:\n%s", data)
+	}
+
+	stdout.Reset()
+	if code = RunScanCommand([]string{"--ports", fmt.Sprint(port), "--skip-verify", "--write-config", "-", "127.0.0.1"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	if !strings.Contains(stdout.String(), VerificationSkipped) || !strings.Contains(stdout.String(), "skipVerify: true") ||
+		strings.Contains(stdout.String(), "domain:") {
+		t.Fatalf("unexpected output:\n%s", stdout.String())
+	}
+
+	if code = RunScanCommand([]string{"--ports", "abc", "127.0.0.1"}, &stdout, &stderr); code != 1 {
+		t.Fatalf("exit code %d", code)
+	}
+	if code = RunScanCommand(nil, &stdout, &stderr); code != 2 {
+		t.Fatalf("exit code %d", code)
+	}
+}
@@ -2,6 +2,8 @@ package common
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
@@ -9,6 +11,7 @@ import (
 	"tlsprobe/common/creator"
 	"strings"
 	"sync"
+	"time"
 )
 
 type ScanHostPorts map[uint]*TLSChecker
@@ -50,23 +53,40 @@ func TCPConnect(addr string, dialer *net.Dialer, retryTimes uint) (conn net.Conn
 	return conn, err
 }
 
-func NewHostScanner(creator creator.Creator, config *HostScannerConfig, wa *WaitPool, ctx context.Context) *HostScanner {
+// NewHostScanner creates a HostScanner, config should be resolved with the module,
+// and rawConfig is the config before resolving.
+func NewHostScanner(creator creator.Creator, config *HostScannerConfig, rawConfig *HostScannerConfig, wa *WaitPool, ctx context.Context) *HostScanner {
 	c, cf := context.WithCancel(ctx)
 	return &HostScanner{
 		Creator:    creator,
 		Config:     *config,
+		RawConfig:  *rawConfig,
 		Ports:      make(ScanHostPorts),
+		failures:   make(map[uint]uint),
 		wa:         wa,
+		parentCtx:  ctx,
 		ctx:        c,
 		cancelFunc: cf,
 	}
 }
 
 type HostScanner struct {
-	Creator    creator.Creator
-	Config     HostScannerConfig
-	Ports      ScanHostPorts
-	wa         *WaitPool
+	Creator   creator.Creator
+	Config    HostScannerConfig
+	RawConfig HostScannerConfig
+	Ports     ScanHostPorts
+	// failures is the consecutive failed probes of the known ports.
+	failures map[uint]uint
+	wa       *WaitPool
+	// children are the HostScanners of every host when scanning a CIDR.
+	children []*HostScanner
+	limiter  *scanLimiter
+	progress scanProgress
+	// onFound is called with every TLS port found instead of adding a TLSChecker to the exporter,
+	// it is used by the scan command.
+	onFound func(checker *TLSChecker, stat *tls.ConnectionState, err error)
+	// parentCtx is used to recreate the HostScanner when its module is changed.
+	parentCtx  context.Context
 	ctx        context.Context
 	cancelFunc context.CancelFunc
 	mux        sync.RWMutex
@@ -77,29 +97,88 @@ func (s *HostScanner) check(port uint, addr string, dialer *net.Dialer) {
 	rawConn, err := TCPConnect(addr, dialer, s.Config.TLSOptions.ReTryTimes)
 	if err != nil && rawConn == nil {
 		log.Trace().Msgf("connect to %s failed: %v, skip it.", addr, err)
+		s.markFailed(port)
 		return
 	}
-	checker := NewTLSChecker(s, s.Config.Host, port, s.Config.TLSOptions)
+	checker := NewTLSChecker(s.checkerCreator(), s.Config.Host, port, s.Config.TLSOptions)
+	checker.Labels = s.Config.Labels
 	// TODO@(xiaoshuo) should add metrics when connect is failed,
 	// should add metric when cert is expired.
 
-	_, err = checker.CheckWithConn(rawConn)
+	// finding the TLS port only needs the handshake, the certificates are verified by the TLSChecker's probes.
+	stat, err := checker.HandshakeWithConn(rawConn)
 	if IsUnconnectedError(err) {
 		log.Debug().Msgf("hostscanner addr: %s got a unconnect error: %v", checker.Addr(), err)
+		s.markFailed(port)
+		return
+	}
+	log.Debug().Msgf("hostscanner addr: %s check error: %v", checker.Addr(), err)
+	// the port which is not TLS any more counts as a failed probe.
+	if !ShouldKeepCheckTLS(err) {
+		s.markFailed(port)
 		return
 	}
 	s.mux.Lock()
+	// the HostScanner may be stopped during the check.
+	if s.ctx.Err() != nil {
+		s.mux.Unlock()
+		return
+	}
 	s.Ports[port] = checker
+	delete(s.failures, port)
 	s.mux.Unlock()
-	log.Debug().Msgf("hostscanner addr: %s check error: %v", checker.Addr(), err)
 
-	if ShouldKeepCheckTLS(err) {
-		log.Debug().Msgf("added TLSChecker host: %s", checker.Addr())
-		Exp.UpdateTLSChecker(context.Background(), checker, s)
+	if s.onFound != nil {
+		s.onFound(checker, stat, err)
+		return
+	}
+	log.Debug().Msgf("added TLSChecker host: %s", checker.Addr())
+	Exp.UpdateTLSChecker(context.Background(), checker, s.checkerCreator())
+}
+
+// checkerCreator returns the creator of the found TLSCheckers, it is the range HostScanner for its children.
+func (s *HostScanner) checkerCreator() creator.Creator {
+	if parent, ok := s.Creator.(*HostScanner); ok {
+		return parent
 	}
+	return s
+}
+
+func (s *HostScanner) checkAndDone(wg *sync.WaitGroup, progress *scanProgress, port uint, addr string, dialer *net.Dialer) {
+	defer wg.Done()
+	defer s.limiter.Release()
+	s.check(port, addr, dialer)
+	if progress != nil {
+		progress.Done()
+	}
+}
+
+// markFailed counts the failed probes of a known port,
+// the port is retired when it fails RetireAfter times in a row.
+func (s *HostScanner) markFailed(port uint) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	checker, exists := s.Ports[port]
+	if !exists {
+		return
+	}
+	s.failures[port]++
+	if s.failures[port] < s.Config.GetRetireAfter() {
+		return
+	}
+	log.Info().Msgf("hostscanner retired addr: %s after %d failed probes", checker.Addr(), s.failures[port])
+	delete(s.Ports, port)
+	delete(s.failures, port)
+	Exp.RemoveTLSChecker(checker.Key())
 }
 
 func (s *HostScanner) Stop() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, child := range s.children {
+		child.Stop()
+	}
+	s.limiter.Stop()
 	for _, c := range s.Ports {
 		Exp.RemoveTLSChecker(c.Key())
 	}
@@ -108,8 +187,107 @@ func (s *HostScanner) Stop() {
 	s.cancelFunc()
 }
 
+// Scan scans all ports, then keeps rescanning all ports every RescanInterval
+// and rechecking the known ports every RecheckInterval.
 func (s *HostScanner) Scan() {
-	for port := uint(10); port < 65536; port++ {
+	if s.Config.GetCIDR() != "" {
+		if err := s.scanRange(); err != nil {
+			log.Error().Msgf("%s stop scanning: %v", s.Config.Key(), err)
+		}
+		return
+	}
+	ports, err := s.Config.ScanPorts()
+	if err != nil {
+		log.Error().Msgf("%s stop scanning: %v", s.Config.Key(), err)
+		return
+	}
+	// revalidate the ports restored from the state file before the full scan.
+	if known := State.KnownPorts(s.Config.Key()); len(known) > 0 {
+		log.Info().Msgf("%s revalidating %d restored ports", s.Config.Key(), len(known))
+		s.scanPorts(known, nil)
+	}
+	s.fullScan(ports)
+
+	var rescanC, recheckC <-chan time.Time
+	if interval := s.Config.GetRescanInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		rescanC = ticker.C
+	}
+	if interval := s.Config.GetRecheckInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		recheckC = ticker.C
+	}
+	if rescanC == nil && recheckC == nil {
+		return
+	}
+	for {
+		select {
+		case <-s.ctx.Done():
+			log.Info().Msgf("%s stopping", s.Config.Key())
+			return
+		case <-rescanC:
+			log.Debug().Msgf("%s starting rescan", s.Config.Key())
+			s.fullScan(ports)
+		case <-recheckC:
+			log.Debug().Msgf("%s starting recheck known ports", s.Config.Key())
+			s.scanPorts(s.KnownPorts(), nil)
+		}
+	}
+}
+
+// ScanOnce scans all ports of the host, or of every host of the CIDR, once and waits for them.
+func (s *HostScanner) ScanOnce() error {
+	if s.Config.GetCIDR() != "" {
+		children, err := s.newRangeChildren()
+		if err != nil {
+			return err
+		}
+		// the hosts are scanned under the range's concurrency limit, not all at once.
+		sem := make(chan struct{}, s.rangeConcurrency())
+		var errMux sync.Mutex
+		var errs []error
+		wg := new(sync.WaitGroup)
+		for _, child := range children {
+			// the children return at once when the scan is stopped.
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(child *HostScanner) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				if err := child.ScanOnce(); err != nil {
+					errMux.Lock()
+					errs = append(errs, err)
+					errMux.Unlock()
+				}
+			}(child)
+		}
+		wg.Wait()
+		return errors.Join(errs...)
+	}
+	ports, err := s.Config.ScanPorts()
+	if err != nil {
+		return err
+	}
+	s.fullScan(ports)
+	return nil
+}
+
+// fullScan scans all ports and records the progress, the scan is not marked finished when it is stopped.
+func (s *HostScanner) fullScan(ports []uint) {
+	s.progress.Reset(len(ports))
+	s.scanPorts(ports, &s.progress)
+	if s.ctx.Err() == nil {
+		s.progress.Finish()
+	}
+}
+
+// scanPorts checks the ports and waits for all of them, progress is nil when it is not a full scan.
+func (s *HostScanner) scanPorts(ports []uint, progress *scanProgress) {
+	wg := new(sync.WaitGroup)
+	defer wg.Wait()
+	for _, port := range ports {
 		select {
 		case <-s.ctx.Done():
 			log.Info().Msgf("%s stopping", s.Config.Key())
@@ -120,8 +298,23 @@ func (s *HostScanner) Scan() {
 		dialer := &net.Dialer{
 			Timeout: s.Config.TLSOptions.GetTimeout(),
 		}
-		s.wa.Run(s.check, port, addr, dialer)
+		if !s.limiter.Acquire(s.ctx) {
+			return
+		}
+		wg.Add(1)
+		s.wa.Run(s.checkAndDone, wg, progress, port, addr, dialer)
+	}
+}
+
+// KnownPorts returns the ports which have been found open.
+func (s *HostScanner) KnownPorts() []uint {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	ports := make(map[uint]struct{}, len(s.Ports))
+	for port := range s.Ports {
+		ports[port] = struct{}{}
 	}
+	return sortPorts(ports)
 }
 
 func (s *HostScanner) GetCreator() creator.Creator {
@@ -129,24 +322,49 @@ func (s *HostScanner) GetCreator() creator.Creator {
 }
 
 func (s *HostScanner) CollectPorts(ch chan<- prometheus.Metric) {
+	if cidr := s.Config.GetCIDR(); cidr != "" {
+		s.collectRange(ch, cidr)
+	}
 	s.mux.RLock()
 	defer s.mux.RUnlock()
+	for _, child := range s.children {
+		child.CollectPorts(ch)
+	}
+	if len(s.children) == 0 {
+		s.collectProgress(ch)
+	}
 	for port, _ := range s.Ports {
-		labels := prometheus.Labels{
-			"port":   fmt.Sprintf("%d", port),
-			"host":   s.Config.Host,
-			"domain": s.Config.TLSOptions.Domain,
-		}
-		descer := prometheus.NewDesc("host_scanner_port", "", nil, labels)
-		m, err := prometheus.NewConstMetric(descer, prometheus.GaugeValue, 1)
-		if err != nil {
-			log.Warn().Msgf("scanner exec NewConstMetric failed, host: %s, error: %v.", s.Config.Host, err)
-			continue
+		sendLabeledGauge(ch, descScannerPort, 1, s.Config.Labels, s.Config.Host, fmt.Sprintf("%d", port), s.Config.TLSOptions.Domain)
+		if Exp.LegacyMetrics() {
+			sendGauge(ch, descV1ScannerPort, 1, fmt.Sprintf("%d", port), s.Config.Host, s.Config.TLSOptions.Domain)
 		}
-		ch <- m
+	}
+}
+
+func (s *HostScanner) collectRange(ch chan<- prometheus.Metric, cidr string) {
+	scanned, total := s.RangeProgress()
+	if total == 0 {
+		return
+	}
+	sendLabeledGauge(ch, descScannerRangeProgress, float64(scanned)/float64(total), s.Config.Labels, cidr, s.Config.TLSOptions.Domain)
+}
+
+// collectProgress exports the progress and the timestamps of the last full scan of the host.
+func (s *HostScanner) collectProgress(ch chan<- prometheus.Metric) {
+	scanned, total := s.progress.Get()
+	startedAt, finishedAt := s.progress.Times()
+	if startedAt.IsZero() {
+		return
+	}
+	host, domain := s.Config.Host, s.Config.TLSOptions.Domain
+	sendLabeledGauge(ch, descScannerPortsScanned, float64(scanned), s.Config.Labels, host, domain)
+	sendLabeledGauge(ch, descScannerPortsTotal, float64(total), s.Config.Labels, host, domain)
+	sendLabeledGauge(ch, descScannerScanStart, float64(startedAt.Unix()), s.Config.Labels, host, domain)
+	if !finishedAt.IsZero() {
+		sendLabeledGauge(ch, descScannerScanFinish, float64(finishedAt.Unix()), s.Config.Labels, host, domain)
 	}
 }
 
 func (s *HostScanner) Describe() string {
-	return fmt.Sprintf("HostScanner: Host: %v.", s.Config.Host)
+	return fmt.Sprintf("HostScanner: Host: %v.", s.Config.Target())
 }
@@ -1,9 +1,17 @@
 package common
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
+	"net"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestPrometheusDesc(t *testing.T) {
@@ -18,3 +26,214 @@ func TestPrometheusDesc(t *testing.T) {
 	}
 	fmt.Println(m.Desc())
 }
+
+func TestHostScannerRetirePort(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	leaf := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com"}}, ca)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{leaf.TLSCertificate()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	port := uint(ln.Addr().(*net.TCPAddr).Port)
+
+	cfg := HostScannerConfig{
+		Host:        "127.0.0.1",
+		TLSOptions:  TLSCheckOptions{Domain: "www.example.com", Timeout: 1000, InsecureSkipVerify: true},
+		RetireAfter: 2,
+	}
+	s := NewHostScanner(nil, &cfg, &cfg, NewWaitPool(10), context.Background())
+	defer s.Stop()
+	s.scanPorts([]uint{port, port + 1}, nil)
+	if known := s.KnownPorts(); len(known) != 1 || known[0] != port {
+		t.Fatalf("known ports should be [%d], but now is: %v", port, known)
+	}
+	checkerKey := s.Ports[port].Key()
+	if _, exists := Exp.TLSCheckers[checkerKey]; !exists {
+		t.Fatalf("TLSChecker %s should be added", checkerKey)
+	}
+
+	ln.Close()
+	s.scanPorts(s.KnownPorts(), nil)
+	if len(s.KnownPorts()) != 1 {
+		t.Fatal("port should not be retired after 1 failed probe")
+	}
+	s.scanPorts(s.KnownPorts(), nil)
+	if len(s.KnownPorts()) != 0 {
+		t.Fatal("port should be retired after 2 failed probes")
+	}
+	if _, exists := Exp.TLSCheckers[checkerKey]; exists {
+		t.Fatalf("TLSChecker %s should be removed", checkerKey)
+	}
+}
+
+func TestHostScannerRetireNonTLSPort(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	leaf := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com"}}, ca)
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{leaf.TLSCertificate()}}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// the port stops serving TLS but keeps accepting connections.
+	var plain atomic.Bool
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if plain.Load() {
+				conn.Write([]byte("SSH-2.0-OpenSSH\r\n"))
+			} else {
+				tls.Server(conn, tlsConfig).Handshake()
+			}
+			conn.Close()
+		}
+	}()
+	port := uint(ln.Addr().(*net.TCPAddr).Port)
+
+	cfg := HostScannerConfig{
+		Host:        "127.0.0.1",
+		TLSOptions:  TLSCheckOptions{Domain: "www.example.com", Timeout: 1000, InsecureSkipVerify: true},
+		RetireAfter: 2,
+	}
+	s := NewHostScanner(nil, &cfg, &cfg, NewWaitPool(10), context.Background())
+	defer s.Stop()
+	s.scanPorts([]uint{port}, nil)
+	if len(s.KnownPorts()) != 1 {
+		t.Fatalf("known ports should be [%d], but now is: %v", port, s.KnownPorts())
+	}
+
+	plain.Store(true)
+	s.scanPorts(s.KnownPorts(), nil)
+	if len(s.KnownPorts()) != 1 || s.failures[port] != 1 {
+		t.Fatalf("the non-TLS handshake should be counted as a failure, failures: %v", s.failures)
+	}
+	s.scanPorts(s.KnownPorts(), nil)
+	if len(s.KnownPorts()) != 0 {
+		t.Fatal("port should be retired after 2 non-TLS handshakes")
+	}
+}
+
+func TestExpandHosts(t *testing.T) {
+	cases := []struct {
+		cidr  string
+		count int
+		first string
+		last  string
+	}{
+		{"10.20.0.0/22", 1022, "10.20.0.1", "10.20.3.254"},
+		{"10.20.0.8/31", 2, "10.20.0.8", "10.20.0.9"},
+		{"10.20.0.8/32", 1, "10.20.0.8", "10.20.0.8"},
+		{"fd00::/126", 4, "fd00::", "fd00::3"},
+	}
+	for _, c := range cases {
+		hosts, err := ExpandHosts(c.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hosts) != c.count || hosts[0] != c.first || hosts[len(hosts)-1] != c.last {
+			t.Fatalf("%s: should be %d hosts from %s to %s, but now is: %d hosts from %s to %s",
+				c.cidr, c.count, c.first, c.last, len(hosts), hosts[0], hosts[len(hosts)-1])
+		}
+	}
+	if _, err := ExpandHosts("10.0.0.0/8"); err == nil {
+		t.Fatal("10.0.0.0/8 should be too large")
+	}
+}
+
+func TestHostScannerScanRange(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	cfg := HostScannerConfig{
+		CIDR:           "127.0.0.0/30",
+		Ports:          fmt.Sprintf("%d", port),
+		TLSOptions:     TLSCheckOptions{Timeout: 500},
+		MaxConnections: 1,
+		RateLimit:      100,
+	}
+	if cfg.Key() != "HostScanner Host: 127.0.0.0/30, domain: " {
+		t.Fatalf("wrong key: %s", cfg.Key())
+	}
+	s := NewHostScanner(nil, &cfg, &cfg, NewWaitPool(10), context.Background())
+	defer s.Stop()
+	s.Scan()
+	if len(s.children) != 2 {
+		t.Fatalf("children should be 2, but now is: %d", len(s.children))
+	}
+	for i := 0; i < 50; i++ {
+		if scanned, total := s.RangeProgress(); total == 2 && scanned == 2 {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	scanned, total := s.RangeProgress()
+	t.Fatalf("range progress should be 2/2, but now is: %d/%d", scanned, total)
+}
+
+func TestHostScannerScanRangeCreator(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	leaf := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com"}}, ca)
+	port := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{leaf.TLSCertificate()}})
+	cfg := HostScannerConfig{
+		CIDR:       "127.0.0.1/32",
+		Ports:      fmt.Sprintf("%d", port),
+		TLSOptions: TLSCheckOptions{Domain: "www.example.com", Timeout: 1000, InsecureSkipVerify: true},
+		// larger than 1e9 makes the interval of the ticker zero.
+		RateLimit: 2000000000,
+	}
+	s := NewHostScanner(nil, &cfg, &cfg, NewWaitPool(10), context.Background())
+	defer s.Stop()
+	if err := s.ScanOnce(); err != nil {
+		t.Fatal(err)
+	}
+	checker := NewTLSChecker(nil, "127.0.0.1", port, cfg.TLSOptions)
+	Exp.CheckerRWMutex.RLock()
+	found, exists := Exp.TLSCheckers[checker.Key()]
+	Exp.CheckerRWMutex.RUnlock()
+	if !exists {
+		t.Fatalf("TLSChecker %s should be added", checker.Key())
+	}
+	if found.Creator != s {
+		t.Fatalf("TLSChecker of the range should be created by the range HostScanner, but now is: %v", found.Creator.Describe())
+	}
+}
+
+func TestHostScannerScanOnceRangeErrors(t *testing.T) {
+	cfg := HostScannerConfig{CIDR: "127.0.0.8/31", Ports: "bogus", MaxConnections: 1}
+	s := NewHostScanner(nil, &cfg, &cfg, NewWaitPool(10), context.Background())
+	defer s.Stop()
+	if c := s.rangeConcurrency(); c != 1 {
+		t.Fatalf("range concurrency should be 1, but now is: %d", c)
+	}
+	err := s.ScanOnce()
+	if err == nil {
+		t.Fatal("the errors of the hosts should be returned")
+	}
+	for _, host := range []string{"127.0.0.8", "127.0.0.9"} {
+		if !strings.Contains(err.Error(), host) {
+			t.Fatalf("the error should contain %s, but now is: %v", host, err)
+		}
+	}
+
+	s.Config.MaxConnections = 0
+	if c := s.rangeConcurrency(); c != 10 {
+		t.Fatalf("range concurrency should be the limit of the WaitPool, but now is: %d", c)
+	}
+}
@@ -0,0 +1,230 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"math/big"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maxRangeHosts limits the hosts of a CIDR, a /16 at most.
+const maxRangeHosts = 1 << 16
+
+// maxRateLimit caps rateLimit, the interval of the limiter's ticker must be positive.
+const maxRateLimit = 1000000
+
+// GetCIDR returns the CIDR to scan, Host is used as the CIDR when it contains a "/".
+func (h *HostScannerConfig) GetCIDR() string {
+	if h.CIDR != "" {
+		return h.CIDR
+	}
+	if strings.Contains(h.Host, "/") {
+		return h.Host
+	}
+	return ""
+}
+
+// Target returns the CIDR or the host of the HostScanner.
+func (h *HostScannerConfig) Target() string {
+	if cidr := h.GetCIDR(); cidr != "" {
+		return cidr
+	}
+	return h.Host
+}
+
+// ExpandHosts returns all host addresses of the CIDR, the network and broadcast addresses of IPv4 are skipped.
+func ExpandHosts(cidr string) ([]string, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	ones, bits := network.Mask.Size()
+	if bits-ones > 16 {
+		return nil, fmt.Errorf("cidr %s is too large, should have at most %d addresses", cidr, maxRangeHosts)
+	}
+	size := 1 << (bits - ones)
+	start := new(big.Int).SetBytes(network.IP)
+	hosts := make([]string, 0, size)
+	for i := 0; i < size; i++ {
+		// skip the network and broadcast addresses of IPv4, except /31 and /32.
+		if bits == 32 && size > 2 && (i == 0 || i == size-1) {
+			continue
+		}
+		b := new(big.Int).Add(start, big.NewInt(int64(i))).Bytes()
+		ip := make(net.IP, len(network.IP))
+		copy(ip[len(ip)-len(b):], b)
+		hosts = append(hosts, ip.String())
+	}
+	return hosts, nil
+}
+
+// scanLimiter caps the concurrency and the connection rate of all scans of a range.
+type scanLimiter struct {
+	sem    chan struct{}
+	ticker *time.Ticker
+}
+
+// newScanLimiter returns nil when there is no limit.
+func newScanLimiter(maxConnections uint, rateLimit uint) *scanLimiter {
+	if maxConnections == 0 && rateLimit == 0 {
+		return nil
+	}
+	l := &scanLimiter{}
+	if maxConnections > 0 {
+		l.sem = make(chan struct{}, maxConnections)
+	}
+	if rateLimit > maxRateLimit {
+		log.Warn().Msgf("rateLimit %d is too large, use %d instead", rateLimit, maxRateLimit)
+		rateLimit = maxRateLimit
+	}
+	if rateLimit > 0 {
+		l.ticker = time.NewTicker(time.Second / time.Duration(rateLimit))
+	}
+	return l
+}
+
+// Acquire waits for a connection slot, it returns false when ctx is done.
+func (l *scanLimiter) Acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+	if l.ticker != nil {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-l.ticker.C:
+		}
+	}
+	if l.sem != nil {
+		select {
+		case <-ctx.Done():
+			return false
+		case l.sem <- struct{}{}:
+		}
+	}
+	return true
+}
+
+func (l *scanLimiter) Release() {
+	if l == nil || l.sem == nil {
+		return
+	}
+	<-l.sem
+}
+
+func (l *scanLimiter) Stop() {
+	if l == nil || l.ticker == nil {
+		return
+	}
+	l.ticker.Stop()
+}
+
+// rangeConcurrency returns how many hosts of the range are scanned at the same time,
+// it is maxConnections of the range, or the limit of the WaitPool when there is no limit.
+func (s *HostScanner) rangeConcurrency() uint {
+	if s.Config.MaxConnections > 0 {
+		return s.Config.MaxConnections
+	}
+	if limit := s.wa.Limit(); limit > 0 {
+		return limit
+	}
+	return 1
+}
+
+// scanProgress counts the checked ports of the current full scan,
+// startedAt and finishedAt are unix nanoseconds of the last full scan.
+type scanProgress struct {
+	scanned    uint64
+	total      uint64
+	startedAt  int64
+	finishedAt int64
+}
+
+func (p *scanProgress) Reset(total int) {
+	atomic.StoreUint64(&p.scanned, 0)
+	atomic.StoreUint64(&p.total, uint64(total))
+	atomic.StoreInt64(&p.startedAt, time.Now().UnixNano())
+}
+
+// Finish marks the full scan finished.
+func (p *scanProgress) Finish() {
+	atomic.StoreInt64(&p.finishedAt, time.Now().UnixNano())
+}
+
+// Times returns the start and the finish time of the last full scan,
+// finishedAt is before startedAt when the scan is running.
+func (p *scanProgress) Times() (startedAt time.Time, finishedAt time.Time) {
+	started, finished := atomic.LoadInt64(&p.startedAt), atomic.LoadInt64(&p.finishedAt)
+	if started > 0 {
+		startedAt = time.Unix(0, started)
+	}
+	if finished > 0 {
+		finishedAt = time.Unix(0, finished)
+	}
+	return startedAt, finishedAt
+}
+
+func (p *scanProgress) Done() {
+	atomic.AddUint64(&p.scanned, 1)
+}
+
+func (p *scanProgress) Get() (scanned uint64, total uint64) {
+	return atomic.LoadUint64(&p.scanned), atomic.LoadUint64(&p.total)
+}
+
+// scanRange expands the CIDR to a child HostScanner per host, all of them share
+// the global WaitPool and the range's limiter.
+func (s *HostScanner) scanRange() error {
+	children, err := s.newRangeChildren()
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		go child.Scan()
+	}
+	return nil
+}
+
+// newRangeChildren creates the child HostScanners of the CIDR and the range's limiter.
+func (s *HostScanner) newRangeChildren() ([]*HostScanner, error) {
+	hosts, err := ExpandHosts(s.Config.GetCIDR())
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("no host in cidr")
+	}
+	children := make([]*HostScanner, 0, len(hosts))
+	s.mux.Lock()
+	s.limiter = newScanLimiter(s.Config.MaxConnections, s.Config.RateLimit)
+	for _, host := range hosts {
+		cfg := s.Config
+		cfg.Host = host
+		cfg.CIDR = ""
+		// the children are created by the range, so their TLSCheckers belong to it.
+		child := NewHostScanner(s, &cfg, &cfg, s.wa, s.ctx)
+		child.limiter = s.limiter
+		child.onFound = s.onFound
+		children = append(children, child)
+	}
+	s.children = children
+	s.mux.Unlock()
+	return children, nil
+}
+
+// RangeProgress returns the checked ports and the total ports of all hosts in the range.
+func (s *HostScanner) RangeProgress() (scanned uint64, total uint64) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	for _, child := range s.children {
+		childScanned, childTotal := child.progress.Get()
+		scanned += childScanned
+		total += childTotal
+	}
+	return scanned, total
+}
@@ -0,0 +1,32 @@
+package common
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectSelfMetrics exports the state of the exporter itself, so it is possible
+// to tell whether the scanners and the autoDiscovers are still working.
+func (e *Exporter) collectSelfMetrics(ch chan<- prometheus.Metric) {
+	if e.waitPool != nil {
+		inFlight, queued := e.waitPool.Stats()
+		sendGauge(ch, descWaitPoolInFlight, float64(inFlight))
+		sendGauge(ch, descWaitPoolQueued, float64(queued))
+		sendGauge(ch, descWaitPoolLimit, float64(e.waitPool.Limit()))
+	}
+
+	e.AutoDiscoverRWMutex.RLock()
+	defer e.AutoDiscoverRWMutex.RUnlock()
+	for name, a := range e.AutoDiscover {
+		status := a.Status()
+		discoverType, labels := a.Config().Type, Labels(a.Config().Labels)
+		sendLabeledGauge(ch, descAutoDiscoverRecords, float64(status.Records), labels, name, discoverType)
+		if !status.LastSuccess.IsZero() {
+			sendLabeledGauge(ch, descAutoDiscoverLastSuccess, float64(status.LastSuccess.Unix()), labels, name, discoverType)
+		}
+		failed := 0.0
+		if status.LastError != "" {
+			failed = 1
+		}
+		sendLabeledGauge(ch, descAutoDiscoverRefreshFailed, failed, labels, name, discoverType)
+	}
+}
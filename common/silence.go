@@ -0,0 +1,319 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SilenceMatcher matches the TLSCheckers, an empty field matches all,
+// the host and the domain are glob patterns like "*.test.example.com".
+type SilenceMatcher struct {
+	Host   string `yaml:"host" json:"host,omitempty"`
+	Domain string `yaml:"domain" json:"domain,omitempty"`
+	Port   uint   `yaml:"port" json:"port,omitempty"`
+	// Labels match the labels of the TLSChecker exactly.
+	Labels Labels `yaml:"labels" json:"labels,omitempty"`
+}
+
+func (m *SilenceMatcher) Validate() error {
+	for _, pattern := range []string{m.Host, m.Domain} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	if m.Host == "" && m.Domain == "" && m.Port == 0 && len(m.Labels) == 0 {
+		return errors.New("silence should match at least one of host, domain, port and labels")
+	}
+	return nil
+}
+
+func (m *SilenceMatcher) Match(t *TLSChecker) bool {
+	if !matchPattern(m.Host, t.Host) || !matchPattern(m.Domain, t.TLSCheckOptions.Domain) {
+		return false
+	}
+	if m.Port != 0 && m.Port != t.Port {
+		return false
+	}
+	for name, value := range m.Labels {
+		if t.Labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func matchPattern(pattern string, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(s))
+	return matched
+}
+
+// Silence mutes the matched TLSCheckers between StartsAt and EndsAt,
+// a zero StartsAt starts immediately and a zero EndsAt never ends.
+type Silence struct {
+	ID       string         `yaml:"id" json:"id"`
+	Matcher  SilenceMatcher `yaml:"match" json:"match"`
+	StartsAt time.Time      `yaml:"startsAt" json:"startsAt"`
+	EndsAt   time.Time      `yaml:"endsAt" json:"endsAt"`
+	// Skip stops probing the matched TLSCheckers, otherwise they are still probed and marked silenced.
+	Skip      bool   `yaml:"skip" json:"skip"`
+	Comment   string `yaml:"comment" json:"comment,omitempty"`
+	CreatedBy string `yaml:"createdBy" json:"createdBy,omitempty"`
+}
+
+func (s *Silence) Validate() error {
+	if err := s.Matcher.Validate(); err != nil {
+		return err
+	}
+	if !s.EndsAt.IsZero() && !s.EndsAt.After(s.StartsAt) {
+		return fmt.Errorf("silence endsAt %s should be after startsAt %s", s.EndsAt, s.StartsAt)
+	}
+	return nil
+}
+
+func (s *Silence) Active(now time.Time) bool {
+	return !now.Before(s.StartsAt) && !s.Expired(now)
+}
+
+func (s *Silence) Expired(now time.Time) bool {
+	return !s.EndsAt.IsZero() && !now.Before(s.EndsAt)
+}
+
+// SilenceManager keeps the silences of the config file and the silences created by the API,
+// the expired silences are removed by Cleanup.
+type SilenceManager struct {
+	// config are the silences of the config file, they are replaced on every reload.
+	config []*Silence
+	// api are the silences created by the API, the key is Silence.ID.
+	api     map[string]*Silence
+	mux     *sync.RWMutex
+	runOnce sync.Once
+}
+
+func NewSilenceManager() *SilenceManager {
+	return &SilenceManager{
+		config: make([]*Silence, 0),
+		api:    make(map[string]*Silence),
+		mux:    new(sync.RWMutex),
+	}
+}
+
+// silenceCleanupInterval is the interval between removing the expired silences.
+const silenceCleanupInterval = time.Minute
+
+// Update replaces the silences of the config file and starts the cleanup on the first call.
+func (m *SilenceManager) Update(ctx context.Context, silences []Silence) {
+	m.SetConfigSilences(silences)
+	m.runOnce.Do(func() {
+		go m.Run(ctx, silenceCleanupInterval)
+	})
+}
+
+// SetConfigSilences replaces the silences of the config file, the invalid silences are ignored.
+func (m *SilenceManager) SetConfigSilences(silences []Silence) {
+	config := make([]*Silence, 0, len(silences))
+	now := time.Now()
+	for i := range silences {
+		s := silences[i]
+		if s.ID == "" {
+			s.ID = fmt.Sprintf("config-%d", i)
+		}
+		if err := s.Validate(); err != nil {
+			log.Error().Msgf("ignore silence %s: %v", s.ID, err)
+			continue
+		}
+		if s.Expired(now) {
+			continue
+		}
+		config = append(config, &s)
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.config = config
+}
+
+// Add creates a silence, the ID is generated.
+func (m *SilenceManager) Add(s Silence) (*Silence, error) {
+	if s.EndsAt.IsZero() {
+		return nil, errors.New("silence endsAt is required")
+	}
+	if s.StartsAt.IsZero() {
+		s.StartsAt = time.Now()
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	if s.Expired(time.Now()) {
+		return nil, fmt.Errorf("silence endsAt %s is expired", s.EndsAt)
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	s.ID = hex.EncodeToString(id)
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.api[s.ID] = &s
+	log.Info().Msgf("created silence %s until %s: %s", s.ID, s.EndsAt, s.Comment)
+	return &s, nil
+}
+
+// Restore adds the silences saved in the state file.
+func (m *SilenceManager) Restore(silences []*Silence) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for _, s := range silences {
+		m.api[s.ID] = s
+	}
+}
+
+// Delete removes a silence created by the API, the silences of the config file can not be deleted.
+func (m *SilenceManager) Delete(id string) bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if _, exists := m.api[id]; !exists {
+		return false
+	}
+	delete(m.api, id)
+	log.Info().Msgf("deleted silence %s", id)
+	return true
+}
+
+// List returns the silences of the config file followed by the silences created by the API.
+func (m *SilenceManager) List() []*Silence {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	silences := append([]*Silence{}, m.config...)
+	silences = append(silences, m.apiSilences()...)
+	return silences
+}
+
+// APISilences returns the silences created by the API sorted by StartsAt.
+func (m *SilenceManager) APISilences() []*Silence {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return m.apiSilences()
+}
+
+func (m *SilenceManager) apiSilences() []*Silence {
+	silences := make([]*Silence, 0, len(m.api))
+	for _, s := range m.api {
+		silences = append(silences, s)
+	}
+	sort.Slice(silences, func(i, j int) bool {
+		if !silences[i].StartsAt.Equal(silences[j].StartsAt) {
+			return silences[i].StartsAt.Before(silences[j].StartsAt)
+		}
+		return silences[i].ID < silences[j].ID
+	})
+	return silences
+}
+
+// Match returns the first active silence matching the TLSChecker, a skipping silence is preferred.
+func (m *SilenceManager) Match(t *TLSChecker, now time.Time) *Silence {
+	var matched *Silence
+	for _, s := range m.List() {
+		if !s.Active(now) || !s.Matcher.Match(t) {
+			continue
+		}
+		if s.Skip {
+			return s
+		}
+		if matched == nil {
+			matched = s
+		}
+	}
+	return matched
+}
+
+// Cleanup removes the expired silences.
+func (m *SilenceManager) Cleanup(now time.Time) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	config := make([]*Silence, 0, len(m.config))
+	for _, s := range m.config {
+		if s.Expired(now) {
+			log.Info().Msgf("silence %s of the config file is expired", s.ID)
+			continue
+		}
+		config = append(config, s)
+	}
+	m.config = config
+	for id, s := range m.api {
+		if s.Expired(now) {
+			log.Info().Msgf("silence %s is expired", id)
+			delete(m.api, id)
+		}
+	}
+}
+
+// Run cleans up the expired silences every interval until ctx is done.
+func (m *SilenceManager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.Cleanup(now)
+		}
+	}
+}
+
+// SilencesHandler serves /api/v1/silences, which manages the silences of Exp.Silences:
+// GET lists all silences, POST creates a silence from the JSON body and DELETE /api/v1/silences?id=xxx deletes a silence.
+// POST and DELETE need the admin bearer token like the admin API.
+func (m *AdminManager) SilencesHandler() http.Handler {
+	return m.Authorize(http.HandlerFunc(handleSilences), true)
+}
+
+func handleSilences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, Exp.Silences.List())
+	case http.MethodPost:
+		var s Silence
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			http.Error(w, fmt.Sprintf("invalid silence: %v", err), http.StatusBadRequest)
+			return
+		}
+		created, err := Exp.Silences.Add(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, created)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if !Exp.Silences.Delete(id) {
+			http.Error(w, fmt.Sprintf("silence %q not found", id), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error().Msgf("write json response failed: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSilenceMatch(t *testing.T) {
+	checker := NewTLSChecker(nil, "10.0.0.1", 443, TLSCheckOptions{Domain: "api.test.example.com"})
+	checker.Labels = Labels{"env": "test"}
+	cases := []struct {
+		matcher SilenceMatcher
+		match   bool
+	}{
+		{SilenceMatcher{Domain: "*.test.example.com"}, true},
+		{SilenceMatcher{Domain: "*.prod.example.com"}, false},
+		{SilenceMatcher{Host: "10.0.0.*", Port: 443}, true},
+		{SilenceMatcher{Host: "10.0.0.*", Port: 8443}, false},
+		{SilenceMatcher{Labels: Labels{"env": "test"}}, true},
+		{SilenceMatcher{Labels: Labels{"env": "prod"}}, false},
+	}
+	for _, c := range cases {
+		if match := c.matcher.Match(checker); match != c.match {
+			t.Fatalf("%+v match should be %v, but now is: %v", c.matcher, c.match, match)
+		}
+	}
+	if err := (&SilenceMatcher{}).Validate(); err == nil {
+		t.Fatal("empty matcher should be invalid")
+	}
+	if err := (&SilenceMatcher{Host: "[10"}).Validate(); err == nil {
+		t.Fatal("bad pattern should be invalid")
+	}
+
+	now := time.Now()
+	m := NewSilenceManager()
+	m.SetConfigSilences([]Silence{
+		{Matcher: SilenceMatcher{Port: 443}},
+		{Matcher: SilenceMatcher{Port: 443}, Skip: true, StartsAt: now.Add(time.Hour)},
+		{Matcher: SilenceMatcher{Port: 443}, Skip: true, EndsAt: now.Add(-time.Hour)},
+	})
+	if len(m.List()) != 2 {
+		t.Fatalf("the expired silence should be ignored, but now is: %d silences", len(m.List()))
+	}
+	if s := m.Match(checker, now); s == nil || s.ID != "config-0" {
+		t.Fatalf("the active silence should match, but now is: %+v", s)
+	}
+	if s := m.Match(checker, now.Add(2*time.Hour)); s == nil || !s.Skip {
+		t.Fatalf("the skipping silence should be preferred, but now is: %+v", s)
+	}
+}
+
+func TestSilenceCleanup(t *testing.T) {
+	m := NewSilenceManager()
+	if _, err := m.Add(Silence{Matcher: SilenceMatcher{Port: 443}}); err == nil {
+		t.Fatal("silence without endsAt should not be created")
+	}
+	s, err := m.Add(Silence{Matcher: SilenceMatcher{Port: 443}, EndsAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetConfigSilences([]Silence{{Matcher: SilenceMatcher{Port: 8443}, EndsAt: time.Now().Add(2 * time.Hour)}})
+	m.Cleanup(time.Now().Add(90 * time.Minute))
+	if silences := m.List(); len(silences) != 1 || silences[0].ID != "config-0" {
+		t.Fatalf("the expired silence %s should be removed, but now is: %v", s.ID, silences)
+	}
+	m.Cleanup(time.Now().Add(3 * time.Hour))
+	if silences := m.List(); len(silences) != 0 {
+		t.Fatalf("all silences should be removed, but now is: %v", silences)
+	}
+}
+
+func TestSilencesHandler(t *testing.T) {
+	old := Exp.Silences
+	Exp.Silences = NewSilenceManager()
+	defer func() { Exp.Silences = old }()
+	oldAdmin := Exp.Admin
+	Exp.Admin = NewAdminManager()
+	defer func() { Exp.Admin = oldAdmin }()
+	request := func(method string, target string, body []byte, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, bytes.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		Exp.Admin.SilencesHandler().ServeHTTP(rec, r)
+		return rec
+	}
+
+	body, _ := json.Marshal(Silence{Matcher: SilenceMatcher{Host: "10.0.0.1"}, EndsAt: time.Now().Add(time.Hour), Skip: true})
+	// the writes are rejected until adminToken is set.
+	if rec := request(http.MethodPost, "/api/v1/silences", body, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("status should be 403, but now is: %d", rec.Code)
+	}
+	Exp.Admin.Update(context.Background(), "secret")
+	if rec := request(http.MethodPost, "/api/v1/silences", body, "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status should be 401, but now is: %d", rec.Code)
+	}
+	rec := request(http.MethodPost, "/api/v1/silences", body, "secret")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status should be 201, but now is: %d %s", rec.Code, rec.Body)
+	}
+	var created Silence
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil || created.ID == "" {
+		t.Fatalf("created silence should have an ID: %+v, %v", created, err)
+	}
+
+	checker := NewTLSChecker(nil, "10.0.0.1", 443, TLSCheckOptions{})
+	ch := make(chan prometheus.Metric, 16)
+	Exp.collectTLSChecker(checker, ch)
+	close(ch)
+	metrics := make([]prometheus.Metric, 0)
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	if len(metrics) != 1 || metrics[0].Desc() != descSilenced {
+		t.Fatalf("the skipped checker should only export tlsprobe_silenced, but now is: %v", metrics)
+	}
+
+	if rec = request(http.MethodDelete, "/api/v1/silences?id="+created.ID, nil, ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status should be 401, but now is: %d", rec.Code)
+	}
+	if rec = request(http.MethodDelete, "/api/v1/silences?id="+created.ID, nil, "secret"); rec.Code != http.StatusNoContent {
+		t.Fatalf("status should be 204, but now is: %d", rec.Code)
+	}
+	// GET does not need the token.
+	rec = request(http.MethodGet, "/api/v1/silences", nil, "")
+	var silences []*Silence
+	if err := json.NewDecoder(rec.Body).Decode(&silences); err != nil || len(silences) != 0 {
+		t.Fatalf("the silence should be deleted, but now is: %v, %v", silences, err)
+	}
+}
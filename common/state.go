@@ -0,0 +1,231 @@
+package common
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"github.com/rs/zerolog/log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var State = NewStateStore()
+
+// StateSnapshot is the discovery state saved to the state file, it is restored on startup
+// so the known ports are checked immediately instead of waiting for a full scan.
+type StateSnapshot struct {
+	SavedAt time.Time `json:"savedAt"`
+	// HostScanners is the known ports of every HostScanner, the key is HostScannerConfig.Key().
+	HostScanners map[string][]uint `json:"hostScanners"`
+	// AutoDiscover is the records of every autoDiscover, the key is autoDiscover name.
+	AutoDiscover map[string]json.RawMessage `json:"autoDiscover"`
+	// ProbeResults is the last certificate of every TLSChecker, the key is TLSChecker.Key().
+	ProbeResults map[string]*ProbeResultState `json:"probeResults"`
+	// Silences are the silences created by the API.
+	Silences []*Silence `json:"silences,omitempty"`
+	// Admin are the objects created by the admin API.
+	Admin []*AdminObject `json:"admin,omitempty"`
+}
+
+type ProbeResultState struct {
+	Host        string    `json:"host"`
+	Port        uint      `json:"port"`
+	Domain      string    `json:"domain"`
+	Owner       string    `json:"owner,omitempty"`
+	Labels      Labels    `json:"labels,omitempty"`
+	Certificate []byte    `json:"certificate"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// StateStore saves the StateSnapshot to a JSON file periodically.
+type StateStore struct {
+	filename string
+	restored *StateSnapshot
+	// autoDiscover is updated by the autoDiscovers after every refresh.
+	autoDiscover map[string]json.RawMessage
+	mux          *sync.RWMutex
+}
+
+func NewStateStore() *StateStore {
+	return &StateStore{
+		restored:     &StateSnapshot{},
+		autoDiscover: make(map[string]json.RawMessage),
+		mux:          new(sync.RWMutex),
+	}
+}
+
+func (s *StateStore) Enabled() bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.filename != ""
+}
+
+// Load restores the snapshot from filename, a missing file is not an error.
+func (s *StateStore) Load(filename string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.filename = filename
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Info().Msgf("state file %s not exists, starting without state", filename)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	snapshot := &StateSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return err
+	}
+	s.restored = snapshot
+	for name, raw := range snapshot.AutoDiscover {
+		s.autoDiscover[name] = raw
+	}
+	for key, r := range snapshot.ProbeResults {
+		cert, err := x509.ParseCertificate(r.Certificate)
+		if err != nil {
+			log.Warn().Msgf("restore probe result of %s failed: %v", key, err)
+			continue
+		}
+		Exp.Inventory.Restore(key, &InventoryEndpoint{
+			Host:        r.Host,
+			Port:        r.Port,
+			Domain:      r.Domain,
+			Owner:       r.Owner,
+			Labels:      r.Labels,
+			Fingerprint: Fingerprint(cert),
+			Certificate: cert,
+			UpdatedAt:   r.UpdatedAt,
+		})
+	}
+	Exp.Silences.Restore(snapshot.Silences)
+	log.Info().Msgf("restored state from %s saved at %s: %d hostScanners, %d autoDiscovers, %d probe results",
+		filename, snapshot.SavedAt, len(snapshot.HostScanners), len(snapshot.AutoDiscover), len(snapshot.ProbeResults))
+	return nil
+}
+
+// KnownPorts returns the restored known ports of the HostScanner.
+func (s *StateStore) KnownPorts(key string) []uint {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.restored.HostScanners[key]
+}
+
+// AdminObjects returns the restored objects created by the admin API.
+func (s *StateStore) AdminObjects() []*AdminObject {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.restored.Admin
+}
+
+func (s *StateStore) AutoDiscoverRecords(name string) json.RawMessage {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.autoDiscover[name]
+}
+
+func (s *StateStore) SetAutoDiscoverRecords(name string, raw json.RawMessage) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if raw == nil {
+		delete(s.autoDiscover, name)
+		return
+	}
+	s.autoDiscover[name] = raw
+}
+
+// Snapshot makes a snapshot of the exporter's current state.
+func (s *StateStore) Snapshot() *StateSnapshot {
+	snapshot := &StateSnapshot{
+		SavedAt:      time.Now(),
+		HostScanners: make(map[string][]uint),
+		AutoDiscover: make(map[string]json.RawMessage),
+		ProbeResults: make(map[string]*ProbeResultState),
+	}
+	Exp.HostScannerRWMutex.RLock()
+	for _, hs := range Exp.HostScanners {
+		hs.mux.RLock()
+		scanners := append([]*HostScanner{hs}, hs.children...)
+		hs.mux.RUnlock()
+		for _, scanner := range scanners {
+			if ports := scanner.KnownPorts(); len(ports) > 0 {
+				snapshot.HostScanners[scanner.Config.Key()] = ports
+			}
+		}
+	}
+	Exp.HostScannerRWMutex.RUnlock()
+
+	Exp.AutoDiscoverRWMutex.RLock()
+	s.mux.RLock()
+	for name := range Exp.AutoDiscover {
+		if raw, exists := s.autoDiscover[name]; exists {
+			snapshot.AutoDiscover[name] = raw
+		}
+	}
+	s.mux.RUnlock()
+	Exp.AutoDiscoverRWMutex.RUnlock()
+
+	for key, e := range Exp.Inventory.Endpoints() {
+		snapshot.ProbeResults[key] = &ProbeResultState{
+			Host:        e.Host,
+			Port:        e.Port,
+			Domain:      e.Domain,
+			Owner:       e.Owner,
+			Labels:      e.Labels,
+			Certificate: e.Certificate.Raw,
+			UpdatedAt:   e.UpdatedAt,
+		}
+	}
+	snapshot.Silences = Exp.Silences.APISilences()
+	snapshot.Admin = Exp.Admin.Objects()
+	return snapshot
+}
+
+// Save writes the snapshot to a temp file and renames it to the state file.
+func (s *StateStore) Save() error {
+	s.mux.RLock()
+	filename := s.filename
+	s.mux.RUnlock()
+	if filename == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.Snapshot())
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// Run saves the state every interval, and saves it again when ctx is done.
+func (s *StateStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.Save(); err != nil {
+				log.Error().Msgf("save state failed: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := s.Save(); err != nil {
+				log.Error().Msgf("save state failed: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStateStoreSaveAndLoad(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	leaf := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com"}}, ca)
+
+	cfg := HostScannerConfig{Host: "10.0.0.1", TLSOptions: TLSCheckOptions{Domain: "www.example.com"}}
+	s := NewHostScanner(nil, &cfg, &cfg, NewWaitPool(1), context.Background())
+	for _, port := range []uint{8443, 443} {
+		checker := NewTLSChecker(s, cfg.Host, port, cfg.TLSOptions)
+		s.Ports[port] = checker
+		Exp.UpdateTLSChecker(context.Background(), checker, s)
+		Exp.Inventory.Observe(checker, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf.cert}}, nil)
+	}
+	Exp.HostScannerRWMutex.Lock()
+	Exp.HostScanners[cfg.Key()] = s
+	Exp.HostScannerRWMutex.Unlock()
+	defer Exp.RemoveHostScanner(cfg.Key())
+
+	filename := filepath.Join(t.TempDir(), "state.json")
+	store := NewStateStore()
+	if err := store.Load(filename); err != nil {
+		t.Fatalf("missing state file should not be an error: %v", err)
+	}
+	store.SetAutoDiscoverRecords("aliyun", json.RawMessage(`{"com":{}}`))
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewStateStore()
+	if err := restored.Load(filename); err != nil {
+		t.Fatal(err)
+	}
+	if ports := restored.KnownPorts(cfg.Key()); !reflect.DeepEqual(ports, []uint{443, 8443}) {
+		t.Fatalf("restored ports should be [443 8443], but now is: %v", ports)
+	}
+	if len(restored.restored.ProbeResults) != 2 {
+		t.Fatalf("restored probe results should be 2, but now is: %d", len(restored.restored.ProbeResults))
+	}
+	// the autoDiscover does not exist in the exporter, so it is not saved.
+	if raw := restored.AutoDiscoverRecords("aliyun"); raw != nil {
+		t.Fatalf("records of removed autoDiscover should not be saved, but now is: %s", raw)
+	}
+}
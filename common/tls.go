@@ -2,12 +2,16 @@ package common
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
 	"net"
+	"reflect"
 	"tlsprobe/common/creator"
 	"strings"
 	"time"
@@ -29,11 +33,91 @@ func ShouldKeepCheckTLS(err error) bool {
 
 }
 
+const (
+	// DefaultCertNoSNI probes the default certificate without sending SNI.
+	DefaultCertNoSNI = "noSNI"
+	// DefaultCertBogusSNI probes the default certificate with a random server name.
+	DefaultCertBogusSNI = "bogusSNI"
+)
+
+// ValidDefaultCert reports whether v is a known value of TLSCheckOptions.DefaultCert.
+func ValidDefaultCert(v string) bool {
+	return v == "" || v == DefaultCertNoSNI || v == DefaultCertBogusSNI
+}
+
 type TLSCheckOptions struct {
 	Domain             string `yaml:"domain"`
 	Timeout            uint   `yaml:"timeout"`
 	InsecureSkipVerify bool   `yaml:"skipVerify"`
 	ReTryTimes         uint   `yaml:"reTryTimes"`
+	// DefaultCert runs an extra handshake to record the fallback certificate,
+	// it should be one of "", "noSNI" or "bogusSNI".
+	DefaultCert string `yaml:"defaultCert"`
+	// CAFile is a PEM bundle of the roots used to verify, the system roots are used when it is empty.
+	CAFile string `yaml:"caFile"`
+	// LegacyCAFile is a PEM bundle of the roots trusted by old clients, like old Android devices.
+	LegacyCAFile string `yaml:"legacyCAFile"`
+	// WarningDays and CriticalDays override the global expiry thresholds.
+	WarningDays  uint `yaml:"warningDays"`
+	CriticalDays uint `yaml:"criticalDays"`
+	// Owner is the team which owns the endpoint, it groups the expiry report.
+	Owner string `yaml:"owner"`
+	// set records the fields present in the config, so a zero value overrides the module.
+	set uint32
+}
+
+// tlsCheckOptionsFields maps the yaml names of TLSCheckOptions to the field indexes.
+var tlsCheckOptionsFields = make(map[string]int)
+
+func init() {
+	typ := reflect.TypeOf(TLSCheckOptions{})
+	for i := 0; i < typ.NumField(); i++ {
+		if name := strings.Split(typ.Field(i).Tag.Get("yaml"), ",")[0]; name != "" {
+			tlsCheckOptionsFields[name] = i
+		}
+	}
+}
+
+func (o *TLSCheckOptions) UnmarshalYAML(node *yaml.Node) error {
+	type plain TLSCheckOptions
+	if err := node.Decode((*plain)(o)); err != nil {
+		return err
+	}
+	if !ValidDefaultCert(o.DefaultCert) {
+		return fmt.Errorf("invalid defaultCert %q, it should be one of %q or %q", o.DefaultCert, DefaultCertNoSNI, DefaultCertBogusSNI)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		o.markSet(node.Content[i].Value)
+	}
+	return nil
+}
+
+// markSet records that the field of the yaml name is set explicitly.
+func (o *TLSCheckOptions) markSet(name string) {
+	if i, exists := tlsCheckOptionsFields[name]; exists {
+		o.set |= 1 << i
+	}
+}
+
+func (o *TLSCheckOptions) isSet(i int) bool {
+	return o.set&(1<<i) != 0
+}
+
+// MergeTLSCheckOptions returns options whose zero fields are filled from base,
+// the fields set explicitly in the config are kept even if they are zero, like skipVerify: false.
+func MergeTLSCheckOptions(options, base TLSCheckOptions) TLSCheckOptions {
+	merged := options
+	mv := reflect.ValueOf(&merged).Elem()
+	bv := reflect.ValueOf(base)
+	for i := 0; i < mv.NumField(); i++ {
+		if !mv.Type().Field(i).IsExported() || options.isSet(i) {
+			continue
+		}
+		if mv.Field(i).IsZero() {
+			mv.Field(i).Set(bv.Field(i))
+		}
+	}
+	return merged
 }
 
 func (o *TLSCheckOptions) GetTimeout() time.Duration {
@@ -47,11 +131,57 @@ func (o *TLSCheckOptions) ToTLSConfig() *tls.Config {
 	}
 }
 
+// ToDefaultCertTLSConfig returns the tls config used to fetch the default certificate,
+// the certificate is only recorded, so verify is always skipped.
+func (o *TLSCheckOptions) ToDefaultCertTLSConfig() *tls.Config {
+	cfg := &tls.Config{
+		InsecureSkipVerify: true,
+	}
+	if o.DefaultCert == DefaultCertBogusSNI {
+		cfg.ServerName = BogusServerName()
+	}
+	return cfg
+}
+
+// BogusServerName returns a random name which should not be served by any virtual host.
+func BogusServerName() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "tlsprobe-default-cert.invalid"
+	}
+	return hex.EncodeToString(b) + ".invalid"
+}
+
 type TLSChecker struct {
 	creator.Creator
 	Host            string `yaml:"host"`
 	Port            uint   `yaml:"port"`
 	TLSCheckOptions `yaml:"TLSCheckOptions"`
+	Expect          TLSExpectations `yaml:"expect"`
+	// Module is the name of the module which fills the unset TLSCheckOptions.
+	Module string `yaml:"module"`
+	// Labels are added to every metric of the TLSChecker.
+	Labels Labels `yaml:"labels"`
+	// RawOptions are the TLSCheckOptions before the module is resolved, they are resolved again when the module changes.
+	RawOptions TLSCheckOptions `yaml:"-"`
+}
+
+// UnmarshalYAML decodes the fields one by one, otherwise the promoted UnmarshalYAML of the embedded TLSCheckOptions
+// would decode the whole TLSChecker as its options.
+func (t *TLSChecker) UnmarshalYAML(node *yaml.Node) error {
+	raw := struct {
+		Host            string          `yaml:"host"`
+		Port            uint            `yaml:"port"`
+		TLSCheckOptions TLSCheckOptions `yaml:"TLSCheckOptions"`
+		Expect          TLSExpectations `yaml:"expect"`
+		Module          string          `yaml:"module"`
+		Labels          Labels          `yaml:"labels"`
+	}{t.Host, t.Port, t.TLSCheckOptions, t.Expect, t.Module, t.Labels}
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+	t.Host, t.Port, t.TLSCheckOptions, t.Expect, t.Module, t.Labels = raw.Host, raw.Port, raw.TLSCheckOptions, raw.Expect, raw.Module, raw.Labels
+	return nil
 }
 
 func NewTLSChecker(creator creator.Creator, host string, port uint, options TLSCheckOptions) *TLSChecker {
@@ -94,11 +224,43 @@ func (t *TLSChecker) Check() (*tls.ConnectionState, error) {
 	return t.CheckWithConn(conn)
 }
 
+// CheckWithConn handshakes and verifies the certificates, the verified chains are set to VerifiedChains.
+// Missing intermediates are fetched by AIA, so the handshake itself always skips verify.
+// The state of the handshake is also returned with a verify error, so the expiry of the leaf is still known.
+// Nothing is verified or fetched when InsecureSkipVerify is set.
 func (t *TLSChecker) CheckWithConn(rawConn net.Conn) (*tls.ConnectionState, error) {
+	stat, err := t.HandshakeWithConn(rawConn)
+	if err != nil || t.InsecureSkipVerify {
+		return stat, err
+	}
+	chains, err := VerifyCertificates(stat.PeerCertificates, t.TLSCheckOptions.Domain, t.CAFile, t.GetTimeout())
+	if err != nil {
+		return stat, fmt.Errorf("tlsChecker verify error: %w", err)
+	}
+	stat.VerifiedChains = chains
+	return stat, nil
+}
+
+// HandshakeWithConn only handshakes without verifying the certificates, the hostScanner uses it to find the TLS ports.
+func (t *TLSChecker) HandshakeWithConn(rawConn net.Conn) (*tls.ConnectionState, error) {
+	cfg := t.ToTLSConfig()
+	cfg.InsecureSkipVerify = true
+	return t.handshake(rawConn, cfg)
+}
+
+// CheckDefaultCert handshakes without the configured SNI to get the fallback certificate.
+func (t *TLSChecker) CheckDefaultCert() (*tls.ConnectionState, error) {
+	conn, err := net.DialTimeout("tcp", t.Addr(), t.GetTimeout())
+	if err != nil {
+		return nil, fmt.Errorf("tls default cert check error: %w", err)
+	}
+	return t.handshake(conn, t.ToDefaultCertTLSConfig())
+}
+
+func (t *TLSChecker) handshake(rawConn net.Conn, cfg *tls.Config) (*tls.ConnectionState, error) {
 	var cancel context.CancelFunc
 	ctx, cancel := context.WithTimeout(context.Background(), t.GetTimeout())
 	defer cancel()
-	cfg := t.ToTLSConfig()
 	conn := tls.Client(rawConn, cfg)
 	err := conn.HandshakeContext(ctx)
 	defer conn.Close()
@@ -112,55 +274,159 @@ func (t *TLSChecker) CheckWithConn(rawConn net.Conn) (*tls.ConnectionState, erro
 	return &stat, nil
 }
 
-func (t *TLSChecker) collectTLSExpireTime(ch chan<- prometheus.Metric, stat *tls.ConnectionState) {
-	labels := prometheus.Labels{
-		"port":   fmt.Sprintf("%d", t.Port),
-		"host":   t.Host,
-		"domain": t.TLSCheckOptions.Domain,
-	}
+func (t *TLSChecker) collectCert(ch chan<- prometheus.Metric, stat *tls.ConnectionState) {
+	labelValues := t.endpointLabelValues()
 	cert := stat.PeerCertificates[0]
-	dNotAfter := prometheus.NewDesc("tls_checker_not_after", "", nil, labels)
-	dNotBefore := prometheus.NewDesc("tls_checker_not_before", "", nil, labels)
-	mNotAfter, err := prometheus.NewConstMetric(dNotAfter, prometheus.GaugeValue, float64(cert.NotAfter.Unix()))
-	mNotBefore, err := prometheus.NewConstMetric(dNotBefore, prometheus.GaugeValue, float64(cert.NotBefore.Unix()))
-	if err != nil {
-		log.Warn().Msgf("tls checker %s:%d exec prometheus.NewConstMetric failed, error: %v", t.Host, t.Port, err)
-		return
+	sendLabeledGauge(ch, descCertInfo, 1, t.Labels, append(labelValues, Fingerprint(cert), cert.Subject.String(), cert.Issuer.String(),
+		cert.SerialNumber.Text(16), strings.Join(cert.DNSNames, ","))...)
+	sendLabeledGauge(ch, descCertNotAfter, float64(cert.NotAfter.Unix()), t.Labels, labelValues...)
+	sendLabeledGauge(ch, descCertNotBefore, float64(cert.NotBefore.Unix()), t.Labels, labelValues...)
+	if Exp.LegacyMetrics() {
+		v1LabelValues := t.v1LabelValues()
+		sendGauge(ch, descV1NotAfter, float64(cert.NotAfter.Unix()), v1LabelValues...)
+		sendGauge(ch, descV1NotBefore, float64(cert.NotBefore.Unix()), v1LabelValues...)
 	}
-	ch <- mNotAfter
-	ch <- mNotBefore
 }
 
-func (t *TLSChecker) CollectTLSStatus(ch chan<- prometheus.Metric) {
+// CollectTLSStatus probes the endpoint and exports the metrics, it returns the probe result.
+func (t *TLSChecker) CollectTLSStatus(ch chan<- prometheus.Metric) (*tls.ConnectionState, error) {
 	stat, err := t.Check()
 	log.Debug().Msgf("host: %v, port: %d, err: %v", t.Host, t.Port, err)
 	var value float64 = 0
-	labels := prometheus.Labels{
-		"port":   fmt.Sprintf("%d", t.Port),
-		"host":   t.Host,
-		"error":  "",
-		"domain": t.TLSCheckOptions.Domain,
-	}
 	if err != nil {
-		labels["error"] = err.Error()
+		sendLabeledGauge(ch, descCheckError, 1, t.Labels, append(t.endpointLabelValues(), ErrorClass(err))...)
+		// the certificate failed to verify is still exported.
+		if stat != nil && len(stat.PeerCertificates) > 0 {
+			t.collectCert(ch, stat)
+		}
+		// the server sent only the leaf and its issuer is not found, which is the most incomplete chain.
+		if stat != nil && IssuerMissing(stat.PeerCertificates, err) {
+			t.sendChainIncomplete(ch, 1)
+		}
 	} else {
 		value = 1
 		if len(stat.PeerCertificates) > 0 {
-			cert := stat.PeerCertificates[0]
-			labels["CertDNSNames"] = fmt.Sprintf("%s", cert.DNSNames)
-			labels["NotBefore"] = cert.NotBefore.String()
-			labels["NotAfter"] = cert.NotAfter.String()
-			//labels["NotBefore"] = strconv.FormatInt(cert.NotBefore.Unix(), 10)
-			//labels["NotAfter"] = strconv.FormatInt(cert.NotAfter.Unix(), 10)
+			t.collectCert(ch, stat)
 		}
-		t.collectTLSExpireTime(ch, stat)
-
+		if len(stat.VerifiedChains) > 0 {
+			t.collectChainIncomplete(ch, stat)
+			t.collectChains(ch, stat)
+		}
+		if t.LegacyCAFile != "" {
+			t.collectLegacyVerify(ch, stat)
+		}
+	}
+	// the expectations are evaluated on every probe which got the certificates, a failed verification fails them.
+	if !t.Expect.Empty() && stat != nil && len(stat.PeerCertificates) > 0 {
+		t.collectExpectations(ch, stat)
+	}
+	if t.DefaultCert != "" {
+		t.collectDefaultCert(ch)
 	}
-	descer := prometheus.NewDesc("tls_checker", "", nil, labels)
-	m, err := prometheus.NewConstMetric(descer, prometheus.GaugeValue, value)
+	sendLabeledGauge(ch, descCheckSuccess, value, t.Labels, t.endpointLabelValues()...)
+	t.collectExpiry(ch, stat, err)
+	if Exp.LegacyMetrics() {
+		t.collectV1Checker(ch, descV1Checker, value, stat, err)
+	}
+	return stat, err
+}
+
+// v1LabelValues returns the port, host and domain labels of schema 1.
+func (t *TLSChecker) v1LabelValues() []string {
+	return []string{fmt.Sprintf("%d", t.Port), t.Host, t.TLSCheckOptions.Domain}
+}
+
+// collectV1Checker exports tls_checker or tls_checker_default_cert of schema 1,
+// whose cert labels are empty when the check failed.
+func (t *TLSChecker) collectV1Checker(ch chan<- prometheus.Metric, desc *prometheus.Desc, value float64, stat *tls.ConnectionState, err error, sni ...string) {
+	var errMsg, dnsNames, notBefore, notAfter string
 	if err != nil {
-		log.Warn().Msgf("tls checker %s:%d exec prometheus.NewConstMetric failed, error: %v", t.Host, t.Port, err)
-		return
+		errMsg = err.Error()
+	} else if len(stat.PeerCertificates) > 0 {
+		cert := stat.PeerCertificates[0]
+		dnsNames = fmt.Sprintf("%s", cert.DNSNames)
+		notBefore = cert.NotBefore.String()
+		notAfter = cert.NotAfter.String()
+	}
+	labelValues := append([]string{fmt.Sprintf("%d", t.Port), t.Host, errMsg, t.TLSCheckOptions.Domain}, sni...)
+	sendGauge(ch, desc, value, append(labelValues, dnsNames, notBefore, notAfter)...)
+}
+
+func (t *TLSChecker) collectChainIncomplete(ch chan<- prometheus.Metric, stat *tls.ConnectionState) {
+	var value float64 = 0
+	if ChainIncomplete(stat.PeerCertificates, stat.VerifiedChains) {
+		value = 1
+	}
+	t.sendChainIncomplete(ch, value)
+}
+
+func (t *TLSChecker) sendChainIncomplete(ch chan<- prometheus.Metric, value float64) {
+	sendLabeledGauge(ch, descChainIncomplete, value, t.Labels, t.endpointLabelValues()...)
+	if Exp.LegacyMetrics() {
+		sendGauge(ch, descV1ChainIncomplete, value, t.v1LabelValues()...)
+	}
+}
+
+// collectChains exports the earliest expiry and the root of every verified chain,
+// a cross-signed certificate makes more than one chain.
+func (t *TLSChecker) collectChains(ch chan<- prometheus.Metric, stat *tls.ConnectionState) {
+	for i, chain := range stat.VerifiedChains {
+		root := chain[len(chain)-1]
+		chainLabelValues := []string{fmt.Sprintf("%d", i), root.Subject.String(), Fingerprint(root)}
+		notAfter := float64(ChainNotAfter(chain).Unix())
+		sendLabeledGauge(ch, descChainNotAfter, notAfter, t.Labels, append(t.endpointLabelValues(), chainLabelValues...)...)
+		if Exp.LegacyMetrics() {
+			sendGauge(ch, descV1ChainNotAfter, notAfter, append(t.v1LabelValues(), chainLabelValues...)...)
+		}
+	}
+}
+
+func (t *TLSChecker) collectLegacyVerify(ch chan<- prometheus.Metric, stat *tls.ConnectionState) {
+	var value float64 = 1
+	errMsg := ""
+	if _, err := VerifyLegacyCertificates(stat.PeerCertificates, t.LegacyCAFile); err != nil {
+		value = 0
+		errMsg = err.Error()
+		log.Debug().Msgf("tls checker %s domain: %s legacy verify failed: %v", t.Addr(), t.TLSCheckOptions.Domain, err)
+	}
+	sendLabeledGauge(ch, descLegacyVerified, value, t.Labels, t.endpointLabelValues()...)
+	if Exp.LegacyMetrics() {
+		sendGauge(ch, descV1LegacyVerified, value, append(t.v1LabelValues(), errMsg)...)
+	}
+}
+
+func (t *TLSChecker) collectExpectations(ch chan<- prometheus.Metric, stat *tls.ConnectionState) {
+	failed := t.Expect.Evaluate(stat.PeerCertificates, stat.VerifiedChains, t.InsecureSkipVerify)
+	var value float64 = 1
+	if len(failed) > 0 {
+		value = 0
+		log.Warn().Msgf("tls checker %s domain: %s failed expectations: %v", t.Addr(), t.TLSCheckOptions.Domain, failed)
+	}
+	sendLabeledGauge(ch, descExpectationSuccess, value, t.Labels, t.endpointLabelValues()...)
+	for _, rule := range failed {
+		sendLabeledGauge(ch, descExpectationFailed, 1, t.Labels, append(t.endpointLabelValues(), rule)...)
+	}
+	if Exp.LegacyMetrics() {
+		sendGauge(ch, descV1Expectation, value, append(t.v1LabelValues(), strings.Join(failed, ","))...)
+	}
+}
+
+func (t *TLSChecker) collectDefaultCert(ch chan<- prometheus.Metric) {
+	stat, err := t.CheckDefaultCert()
+	log.Debug().Msgf("default cert host: %v, port: %d, err: %v", t.Host, t.Port, err)
+	var value float64 = 0
+	labelValues := append(t.endpointLabelValues(), t.DefaultCert)
+	if err == nil && len(stat.PeerCertificates) > 0 {
+		value = 1
+		cert := stat.PeerCertificates[0]
+		sendLabeledGauge(ch, descDefaultCertInfo, 1, t.Labels, append(labelValues, Fingerprint(cert), cert.Subject.String(), strings.Join(cert.DNSNames, ","))...)
+		sendLabeledGauge(ch, descDefaultCertNotAfter, float64(cert.NotAfter.Unix()), t.Labels, labelValues...)
+		if Exp.LegacyMetrics() {
+			sendGauge(ch, descV1DefaultCertNotAfter, float64(cert.NotAfter.Unix()), append(t.v1LabelValues(), t.DefaultCert)...)
+		}
+	}
+	sendLabeledGauge(ch, descDefaultCertSuccess, value, t.Labels, labelValues...)
+	if Exp.LegacyMetrics() {
+		t.collectV1Checker(ch, descV1DefaultCert, value, stat, err, t.DefaultCert)
 	}
-	ch <- m
 }
@@ -1,9 +1,17 @@
 package common
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
+	"math/big"
+	"net"
 	"testing"
+	"time"
 )
 
 func TestTLSCheck(t *testing.T) {
@@ -28,3 +36,119 @@ func TestShouldKeepCheckTLS(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func (c *testCert) TLSCertificate(chain ...*testCert) tls.Certificate {
+	certificate := tls.Certificate{
+		Certificate: [][]byte{c.der},
+		PrivateKey:  c.key,
+	}
+	for _, cc := range chain {
+		certificate.Certificate = append(certificate.Certificate, cc.der)
+	}
+	return certificate
+}
+
+// newTestCert creates a certificate signed by parent, or a self-signed CA when parent is nil.
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template.SerialNumber == nil {
+		template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	}
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Now().Add(24 * time.Hour)
+	}
+	signerCert, signerKey := template, key
+	if parent != nil {
+		signerCert, signerKey = parent.cert, parent.key
+	} else {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// newTestTLSServer serves TLS on a random local port and returns its port.
+func newTestTLSServer(t *testing.T, cfg *tls.Config) uint {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+	return uint(ln.Addr().(*net.TCPAddr).Port)
+}
+
+func TestCheckDefaultCert(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	vhost := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com"}}, ca)
+	fallback := newTestCert(t, &x509.Certificate{
+		DNSNames:  []string{"default.example.com"},
+		NotBefore: time.Now().Add(-48 * time.Hour),
+		NotAfter:  time.Now().Add(-24 * time.Hour),
+	}, ca)
+	vhostCert, fallbackCert := vhost.TLSCertificate(), fallback.TLSCertificate()
+	port := newTestTLSServer(t, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName == "www.example.com" {
+				return &vhostCert, nil
+			}
+			return &fallbackCert, nil
+		},
+	})
+
+	for _, mode := range []string{DefaultCertNoSNI, DefaultCertBogusSNI} {
+		checker := NewTLSChecker(nil, "127.0.0.1", port, TLSCheckOptions{
+			Domain:             "www.example.com",
+			InsecureSkipVerify: true,
+			DefaultCert:        mode,
+		})
+		stat, err := checker.Check()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.PeerCertificates[0].DNSNames[0] != "www.example.com" {
+			t.Fatalf("%s: sni cert should be www.example.com, got: %v", mode, stat.PeerCertificates[0].DNSNames)
+		}
+		stat, err = checker.CheckDefaultCert()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.PeerCertificates[0].DNSNames[0] != "default.example.com" {
+			t.Fatalf("%s: default cert should be default.example.com, got: %v", mode, stat.PeerCertificates[0].DNSNames)
+		}
+	}
+}
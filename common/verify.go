@@ -0,0 +1,306 @@
+package common
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxAIADepth limits how many issuers are chased for one certificate.
+const maxAIADepth = 4
+
+const (
+	// defaultAIACacheSize limits the cached urls, the oldest one is evicted when it is full.
+	defaultAIACacheSize = 1024
+	// aiaFailureTTL is how long a failed url is not downloaded again.
+	aiaFailureTTL = 10 * time.Minute
+)
+
+var AIA = NewAIAFetcher()
+
+var caFiles = &caFileCache{pools: make(map[string]*caFilePool)}
+
+// VerifyCertificates verifies the certificates sent by the server against the roots in caFile,
+// or the system roots when caFile is empty. When the server does not send the intermediates,
+// they are fetched from the AIA caIssuers url and the chains are verified again.
+func VerifyCertificates(certs []*x509.Certificate, domain string, caFile string, timeout time.Duration) ([][]*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no peer certificates")
+	}
+	roots, err := caFiles.Get(caFile)
+	if err != nil {
+		return nil, err
+	}
+	leaf := certs[0]
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+	}
+	chains, err := leaf.Verify(opts)
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	if err != nil && errors.As(err, &unknownAuthorityErr) && len(leaf.IssuingCertificateURL) > 0 {
+		fetched, fetchErr := AIA.FetchIssuers(leaf, timeout)
+		if fetchErr != nil {
+			return nil, fmt.Errorf("%w, fetch AIA issuers failed: %v", err, fetchErr)
+		}
+		for _, c := range fetched {
+			intermediates.AddCert(c)
+		}
+		chains, err = leaf.Verify(opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if domain != "" {
+		if err := leaf.VerifyHostname(domain); err != nil {
+			return chains, err
+		}
+	}
+	return chains, nil
+}
+
+// VerifyLegacyCertificates verifies the certificates against the legacy client roots in caFile.
+// Legacy clients do not fetch the missing intermediates, so AIA is not chased.
+func VerifyLegacyCertificates(certs []*x509.Certificate, caFile string) ([][]*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no peer certificates")
+	}
+	roots, err := caFiles.Get(caFile)
+	if err != nil {
+		return nil, err
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	return certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+}
+
+// ChainNotAfter returns the earliest NotAfter of all certificates in the chain.
+func ChainNotAfter(chain []*x509.Certificate) time.Time {
+	var notAfter time.Time
+	for i, c := range chain {
+		if i == 0 || c.NotAfter.Before(notAfter) {
+			notAfter = c.NotAfter
+		}
+	}
+	return notAfter
+}
+
+// IssuerMissing reports whether the server sent only the leaf, which failed to verify because its issuer is unknown,
+// the issuer could not be fetched by AIA either. A self-signed leaf is not missing its issuer.
+func IssuerMissing(certs []*x509.Certificate, err error) bool {
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	if len(certs) != 1 || !errors.As(err, &unknownAuthorityErr) {
+		return false
+	}
+	return !bytes.Equal(certs[0].RawIssuer, certs[0].RawSubject)
+}
+
+// ChainIncomplete reports whether the server did not send all the intermediates of the verified chains.
+func ChainIncomplete(certs []*x509.Certificate, chains [][]*x509.Certificate) bool {
+	if len(chains) == 0 {
+		return false
+	}
+	sent := make(map[string]struct{}, len(certs))
+	for _, c := range certs {
+		sent[string(c.Raw)] = struct{}{}
+	}
+	for _, chain := range chains {
+		complete := true
+		// skip the leaf and the root.
+		for i := 1; i < len(chain)-1; i++ {
+			if _, exists := sent[string(chain[i].Raw)]; !exists {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			return false
+		}
+	}
+	return true
+}
+
+// aiaCacheEntry is the issuers downloaded from a url, or the error of the failed download.
+type aiaCacheEntry struct {
+	certs     []*x509.Certificate
+	err       error
+	fetchedAt time.Time
+}
+
+// AIAFetcher downloads the issuers from the AIA caIssuers url and caches them by url,
+// a failed url is cached for aiaFailureTTL so it is not downloaded by every probe.
+type AIAFetcher struct {
+	cache map[string]*aiaCacheEntry
+	size  int
+	mux   *sync.RWMutex
+}
+
+func NewAIAFetcher() *AIAFetcher {
+	return &AIAFetcher{
+		cache: make(map[string]*aiaCacheEntry),
+		size:  defaultAIACacheSize,
+		mux:   new(sync.RWMutex),
+	}
+}
+
+// FetchIssuers returns the issuers of cert, and the issuers of the issuers until a self-signed one.
+func (a *AIAFetcher) FetchIssuers(cert *x509.Certificate, timeout time.Duration) ([]*x509.Certificate, error) {
+	issuers := make([]*x509.Certificate, 0)
+	current := cert
+	for depth := 0; depth < maxAIADepth && len(current.IssuingCertificateURL) > 0; depth++ {
+		certs, err := a.fetch(current.IssuingCertificateURL, timeout)
+		if err != nil {
+			if len(issuers) > 0 {
+				break
+			}
+			return nil, err
+		}
+		issuers = append(issuers, certs...)
+		current = certs[0]
+		if current.CheckSignatureFrom(current) == nil {
+			break
+		}
+	}
+	return issuers, nil
+}
+
+func (a *AIAFetcher) fetch(urls []string, timeout time.Duration) ([]*x509.Certificate, error) {
+	var lastErr error
+	for _, url := range urls {
+		a.mux.RLock()
+		entry, exists := a.cache[url]
+		a.mux.RUnlock()
+		if exists && entry.err == nil {
+			return entry.certs, nil
+		}
+		if exists && time.Since(entry.fetchedAt) < aiaFailureTTL {
+			lastErr = entry.err
+			continue
+		}
+		var certs []*x509.Certificate
+		certs, lastErr = a.download(url, timeout)
+		a.store(url, &aiaCacheEntry{certs: certs, err: lastErr, fetchedAt: time.Now()})
+		if lastErr != nil {
+			continue
+		}
+		return certs, nil
+	}
+	return nil, lastErr
+}
+
+// store caches the entry of url, the oldest entry is evicted when the cache is full.
+func (a *AIAFetcher) store(url string, entry *aiaCacheEntry) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if _, exists := a.cache[url]; !exists && len(a.cache) >= a.size {
+		oldest := ""
+		for u, e := range a.cache {
+			if oldest == "" || e.fetchedAt.Before(a.cache[oldest].fetchedAt) {
+				oldest = u
+			}
+		}
+		delete(a.cache, oldest)
+	}
+	a.cache[url] = entry
+}
+
+func (a *AIAFetcher) download(url string, timeout time.Duration) ([]*x509.Certificate, error) {
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get %s got status code: %d", url, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	certs, err := ParseCertificates(body)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificates from %s failed: %w", url, err)
+	}
+	return certs, nil
+}
+
+// ParseCertificates parses DER or PEM encoded certificates.
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	if certs, err := x509.ParseCertificates(data); err == nil && len(certs) > 0 {
+		return certs, nil
+	}
+	certs := make([]*x509.Certificate, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	return certs, nil
+}
+
+type caFilePool struct {
+	modTime time.Time
+	pool    *x509.CertPool
+}
+
+// caFileCache caches the cert pools loaded from ca files, and reloads them when the file is modified.
+type caFileCache struct {
+	pools map[string]*caFilePool
+	mux   sync.Mutex
+}
+
+// Get returns the cert pool of filename, nil means the system roots.
+func (c *caFileCache) Get(filename string) (*x509.CertPool, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if p, exists := c.pools[filename]; exists && p.modTime.Equal(info.ModTime()) {
+		return p.pool, nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in ca file: %s", filename)
+	}
+	c.pools[filename] = &caFilePool{modTime: info.ModTime(), pool: pool}
+	return pool, nil
+}
@@ -0,0 +1,247 @@
+package common
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/prometheus/client_golang/prometheus"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func writeCAFile(t *testing.T, certs ...*testCert) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "ca.pem")
+	data := make([]byte, 0)
+	for _, c := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})...)
+	}
+	if err := os.WriteFile(filename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestCheckWithAIAChasing(t *testing.T) {
+	root := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test root"}}, nil)
+	intermediate := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test intermediate"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, root)
+
+	// local stand-in for the caIssuers url.
+	var hits int32
+	aiaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write(intermediate.der)
+	}))
+	defer aiaServer.Close()
+
+	leaf := newTestCert(t, &x509.Certificate{
+		DNSNames:              []string{"www.example.com"},
+		IssuingCertificateURL: []string{aiaServer.URL + "/intermediate.crt"},
+	}, intermediate)
+	caFile := writeCAFile(t, root)
+
+	cases := []struct {
+		name       string
+		cert       tls.Certificate
+		incomplete bool
+	}{
+		{"full chain", leaf.TLSCertificate(intermediate), false},
+		{"leaf only", leaf.TLSCertificate(), true},
+	}
+	for _, c := range cases {
+		cert := c.cert
+		port := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+		checker := NewTLSChecker(nil, "127.0.0.1", port, TLSCheckOptions{
+			Domain: "www.example.com",
+			CAFile: caFile,
+		})
+		for i := 0; i < 2; i++ {
+			stat, err := checker.Check()
+			if err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			if incomplete := ChainIncomplete(stat.PeerCertificates, stat.VerifiedChains); incomplete != c.incomplete {
+				t.Fatalf("%s: chain incomplete should be %v, but now is: %v", c.name, c.incomplete, incomplete)
+			}
+		}
+	}
+	// the intermediate should be fetched once and cached.
+	if hits != 1 {
+		t.Fatalf("AIA server should be requested once, but now is: %d", hits)
+	}
+
+	// without the AIA url the leaf only chain can not be verified.
+	noAIALeaf := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com"}}, intermediate)
+	port := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{noAIALeaf.TLSCertificate()}})
+	checker := NewTLSChecker(nil, "127.0.0.1", port, TLSCheckOptions{
+		Domain: "www.example.com",
+		CAFile: caFile,
+	})
+	stat, err := checker.Check()
+	if err == nil {
+		t.Fatal("verify should be failed without AIA url")
+	}
+	if !IssuerMissing(stat.PeerCertificates, err) {
+		t.Fatalf("the issuer of the leaf only chain should be missing, err: %v", err)
+	}
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(checkersCollector{checker}); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	incomplete := -1.0
+	for _, f := range families {
+		if f.GetName() == "tlsprobe_chain_incomplete" && len(f.GetMetric()) == 1 {
+			incomplete = f.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	if incomplete != 1 {
+		t.Fatalf("the leaf only chain failed to verify should be incomplete, but now is: %v", incomplete)
+	}
+	// a self-signed certificate has no issuer to send.
+	if IssuerMissing([]*x509.Certificate{root.cert}, x509.UnknownAuthorityError{}) {
+		t.Fatal("the issuer of a self-signed certificate should not be missing")
+	}
+}
+
+func TestAIAFetcherCache(t *testing.T) {
+	root := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test root"}}, nil)
+	var hits, failedHits int32
+	aiaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.crt" {
+			atomic.AddInt32(&failedHits, 1)
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&hits, 1)
+		w.Write(root.der)
+	}))
+	defer aiaServer.Close()
+
+	a := NewAIAFetcher()
+	a.size = 2
+	// the failed url is not downloaded again until aiaFailureTTL passed.
+	missing := []string{aiaServer.URL + "/missing.crt"}
+	for i := 0; i < 2; i++ {
+		if _, err := a.fetch(missing, time.Second); err == nil {
+			t.Fatal("fetch missing url should be failed")
+		}
+	}
+	if failedHits != 1 {
+		t.Fatalf("failed url should be requested once, but now is: %d", failedHits)
+	}
+	a.cache[missing[0]].fetchedAt = time.Now().Add(-aiaFailureTTL)
+	a.fetch(missing, time.Second)
+	if failedHits != 2 {
+		t.Fatalf("failed url should be requested again after aiaFailureTTL, but now is: %d", failedHits)
+	}
+
+	// the cache is bounded, the oldest url is evicted.
+	for _, name := range []string{"a", "b", "a"} {
+		if _, err := a.fetch([]string{aiaServer.URL + "/" + name + ".crt"}, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(a.cache) != 2 || hits != 2 {
+		t.Fatalf("cache should keep 2 urls and request 2 times, but now is: %d urls, %d requests", len(a.cache), hits)
+	}
+	if _, exists := a.cache[missing[0]]; exists {
+		t.Fatal("the oldest url should be evicted")
+	}
+}
+
+func TestCheckSkipVerifyWithoutAIA(t *testing.T) {
+	root := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test root"}}, nil)
+	var hits int32
+	aiaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write(root.der)
+	}))
+	defer aiaServer.Close()
+	leaf := newTestCert(t, &x509.Certificate{
+		DNSNames:              []string{"www.example.com"},
+		IssuingCertificateURL: []string{aiaServer.URL + "/skip-verify.crt"},
+	}, root)
+	port := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{leaf.TLSCertificate()}})
+	checker := NewTLSChecker(nil, "127.0.0.1", port, TLSCheckOptions{Domain: "www.example.com", InsecureSkipVerify: true})
+	stat, err := checker.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stat.VerifiedChains) != 0 || hits != 0 {
+		t.Fatalf("skipVerify should not verify or fetch AIA, got %d chains and %d AIA requests", len(stat.VerifiedChains), hits)
+	}
+}
+
+func TestCheckCrossSignedChains(t *testing.T) {
+	newRoot := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "new root"}}, nil)
+	oldRoot := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "old root"}}, nil)
+	// the new root cross-signed by the old root, it expires earlier than the new root.
+	crossNotAfter := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	crossDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               newRoot.cert.Subject,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              crossNotAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, oldRoot.cert, &newRoot.key.PublicKey, oldRoot.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cross := &testCert{der: crossDER}
+	leaf := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com"}}, newRoot)
+	port := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{leaf.TLSCertificate(cross)}})
+
+	checker := NewTLSChecker(nil, "127.0.0.1", port, TLSCheckOptions{
+		Domain: "www.example.com",
+		CAFile: writeCAFile(t, newRoot, oldRoot),
+	})
+	stat, err := checker.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stat.VerifiedChains) != 2 {
+		t.Fatalf("verified chains should be 2, but now is: %d", len(stat.VerifiedChains))
+	}
+	for _, chain := range stat.VerifiedChains {
+		root := chain[len(chain)-1]
+		switch root.Subject.CommonName {
+		case "new root":
+			if !ChainNotAfter(chain).Equal(leaf.cert.NotAfter) {
+				t.Fatalf("new root chain not after should be %s, but now is: %s", leaf.cert.NotAfter, ChainNotAfter(chain))
+			}
+		case "old root":
+			if !ChainNotAfter(chain).Equal(crossNotAfter) {
+				t.Fatalf("old root chain not after should be %s, but now is: %s", crossNotAfter, ChainNotAfter(chain))
+			}
+		default:
+			t.Fatalf("unexpected root: %s", root.Subject)
+		}
+	}
+
+	if _, err := VerifyLegacyCertificates(stat.PeerCertificates, writeCAFile(t, oldRoot)); err != nil {
+		t.Fatalf("legacy verify with old root should be passed: %v", err)
+	}
+	otherRoot := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "other root"}}, nil)
+	if _, err := VerifyLegacyCertificates(stat.PeerCertificates, writeCAFile(t, otherRoot)); err == nil {
+		t.Fatal("legacy verify with other root should be failed")
+	}
+}
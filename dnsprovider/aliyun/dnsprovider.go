@@ -2,6 +2,7 @@ package aliyun
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	dnscli "github.com/alibabacloud-go/alidns-20150109/v4/client"
 	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
@@ -54,6 +55,13 @@ type DNSProvider struct {
 	domains     *dnsprovider.Domain
 	lastDomains *dnsprovider.Domain
 	cfg         *autodiscover.Config
+	status      *autodiscover.StatusRecorder
+	// refreshErr is the last error of the current refresh.
+	refreshErr error
+	// refreshCh triggers a refresh out of the schedule.
+	refreshCh chan struct{}
+	// dryRun only fetches the records, no hostScanner is made or removed.
+	dryRun bool
 }
 
 func NewDNSProvider(parentCtx context.Context, name string, cli *dnscli.Client, cfg *autodiscover.Config, creator creator.Creator) *DNSProvider {
@@ -69,6 +77,8 @@ func NewDNSProvider(parentCtx context.Context, name string, cli *dnscli.Client,
 		domains:    dnsprovider.NewDomain(),
 		cfg:        cfg,
 		Creator:    creator,
+		status:     autodiscover.NewStatusRecorder(),
+		refreshCh:  make(chan struct{}, 1),
 	}
 }
 
@@ -94,6 +104,7 @@ func (d *DNSProvider) getDomains() {
 	// switch domains to lastDomains and reset domains.
 	d.lastDomains = d.domains
 	d.domains = dnsprovider.NewDomain()
+	d.refreshErr = nil
 	// start to fetch domains.
 	for ; ; pageNum++ {
 		// handle stop event.
@@ -123,6 +134,7 @@ func (d *DNSProvider) getDomains() {
 		// out of retryTimes break.
 		if err != nil {
 			log.Warn().Msgf("DNSProvider %s starting get domains failed: out of retry times", d.Name)
+			d.refreshErr = err
 			break
 		}
 		log.Debug().Msgf("DNSProvider %s got domains: %d", d.Name, len(resp.Body.Domains.Domain))
@@ -137,7 +149,15 @@ func (d *DNSProvider) getDomains() {
 		}
 
 	}
-	dnsprovider.RefreshResources(d.lastDomains.Records, d.domains.Records)
+	if !d.dryRun {
+		dnsprovider.RefreshResources(d.lastDomains.Records, d.domains.Records)
+		// the incomplete records must not replace the saved ones.
+		if d.refreshErr == nil {
+			dnsprovider.SaveDomain(d.Name, d.domains)
+		}
+	}
+	d.status.Record(d.refreshErr, d.domains.Count())
+	d.status.SetRecords(d.domains)
 }
 
 func (d *DNSProvider) getDomainRecords(domainName string) {
@@ -173,6 +193,7 @@ func (d *DNSProvider) getDomainRecords(domainName string) {
 		// out of retryTimes break.
 		if err != nil {
 			log.Warn().Msgf("DNSProvider %s starting get domainName records failed: out of retry times", d.Name)
+			d.refreshErr = err
 			break
 		}
 		log.Debug().Msgf("DNSProvider %s got domainName records: %d", d.Name, len(resp.Body.DomainRecords.Record))
@@ -186,7 +207,9 @@ func (d *DNSProvider) getDomainRecords(domainName string) {
 				log.Debug().Msgf("aliyun dnsprovider add to domains error: %v", err)
 				continue
 			}
-			dnsprovider.MakeHostScanner(d.ctx, record, d)
+			if !d.dryRun {
+				dnsprovider.MakeHostScanner(d.ctx, record, d.cfg, d)
+			}
 		}
 		if len(resp.Body.DomainRecords.Record) < int(pageSize) {
 			break
@@ -195,6 +218,13 @@ func (d *DNSProvider) getDomainRecords(domainName string) {
 	return
 }
 
+// FetchDomain fetches all records once without making hostScanners, the DNSProvider should not be started.
+func (d *DNSProvider) FetchDomain() (*dnsprovider.Domain, error) {
+	d.dryRun = true
+	d.getDomains()
+	return d.domains, d.refreshErr
+}
+
 func (d *DNSProvider) DeleteRecord(domainName string, recordName string) {
 
 	// delete host scanner.
@@ -203,11 +233,15 @@ func (d *DNSProvider) DeleteRecord(domainName string, recordName string) {
 
 func (d *DNSProvider) Start() error {
 	go func() {
+		d.domains = dnsprovider.RestoreDomain(d.ctx, d.cfg, d)
+		d.status.SetRecords(d.domains)
 		d.getDomains()
 		for {
 			select {
 			case <-time.After(time.Minute * 10):
 				d.getDomains()
+			case <-d.refreshCh:
+				d.getDomains()
 			case <-d.ctx.Done():
 				return
 			}
@@ -233,6 +267,23 @@ func (d *DNSProvider) Key() string {
 	return d.Describe()
 }
 
+func (d *DNSProvider) Status() autodiscover.Status {
+	return d.status.Status()
+}
+
+// Refresh triggers a refresh, it is ignored when a refresh is already pending.
+func (d *DNSProvider) Refresh() error {
+	select {
+	case d.refreshCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (d *DNSProvider) Records() json.Marshaler {
+	return d.status.Records()
+}
+
 func (d *DNSProvider) GetCreator() creator.Creator {
 	return d.Creator
 }
@@ -0,0 +1,166 @@
+package dnsprovider
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"tlsprobe/autodiscover"
+	"tlsprobe/common"
+)
+
+// DryRunner is implemented by the DNS providers which can fetch the records once without making hostScanners.
+type DryRunner interface {
+	FetchDomain() (*Domain, error)
+}
+
+// RunDiscoverCommand runs "tlsprobe discover [flags] name", it runs the autoDiscover of the config once and prints
+// the record tree and the hostScanners it would make, no scan is started.
+// It returns 0 on success, 1 when the autoDiscover failed and 2 when the usage is invalid.
+func RunDiscoverCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := pflag.NewFlagSet("discover", pflag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: tlsprobe discover [flags] name")
+		flags.PrintDefaults()
+	}
+	configFilename := flags.StringP("config", "c", "./config.yml", "config name.")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	name := flags.Arg(0)
+
+	data, err := os.ReadFile(*configFilename)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	cfg := common.DefaultConfig()
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		fmt.Fprintf(stderr, "parse config %s failed: %v\n", *configFilename, err)
+		return 1
+	}
+	var autoDiscoverConfig *autodiscover.Config
+	for i := range cfg.AutoDiscover {
+		if cfg.AutoDiscover[i].Name == name {
+			autoDiscoverConfig = &cfg.AutoDiscover[i]
+			break
+		}
+	}
+	if autoDiscoverConfig == nil {
+		fmt.Fprintf(stderr, "autoDiscover %s not found in %s\n", name, *configFilename)
+		return 1
+	}
+	// the ownership labels are merged into the generated hostScanners.
+	common.Exp.SetOwnershipRules(cfg.Ownership)
+
+	a, err := autodiscover.CreateAutoDiscover(context.Background(), autoDiscoverConfig, nil)
+	if err != nil {
+		fmt.Fprintf(stderr, "create autoDiscover %s failed: %v\n", name, err)
+		return 1
+	}
+	runner, ok := a.(DryRunner)
+	if !ok {
+		fmt.Fprintf(stderr, "autoDiscover type %s does not support dry run\n", autoDiscoverConfig.Type)
+		return 1
+	}
+	domain, err := runner.FetchDomain()
+	code := 0
+	if err != nil {
+		fmt.Fprintf(stderr, "autoDiscover %s fetch records failed, the records are incomplete: %v\n", name, err)
+		code = 1
+	}
+
+	fmt.Fprintf(stdout, "records: %d\n", domain.Count())
+	writeRecordTree(stdout, domain.Records)
+	hostScanners := make([]common.HostScannerConfig, 0)
+	// a record with both values and children is returned twice by GetRealRecords.
+	seen := make(map[string]struct{})
+	for _, record := range sortedRecords(domain.Records) {
+		for _, r := range GetRealRecords(record) {
+			for _, hostScannerConfig := range RecordToHostScannerConfig(r, autoDiscoverConfig) {
+				if _, exists := seen[hostScannerConfig.Key()]; exists {
+					continue
+				}
+				seen[hostScannerConfig.Key()] = struct{}{}
+				hostScanners = append(hostScanners, hostScannerConfig)
+			}
+		}
+	}
+	var node yaml.Node
+	if err := node.Encode(map[string][]common.HostScannerConfig{"hostScannersConfig": hostScanners}); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	// the key is kept when no hostScanner is made.
+	for _, item := range node.Content[1].Content {
+		omitZeroFields(item)
+	}
+	snippet, err := yaml.Marshal(&node)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "\nhostScanners: %d\n", len(hostScanners))
+	stdout.Write(snippet)
+	return code
+}
+
+// omitZeroFields removes the fields whose values are zero or empty, so only the set fields are printed.
+func omitZeroFields(node *yaml.Node) {
+	for _, child := range node.Content {
+		omitZeroFields(child)
+	}
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	content := make([]*yaml.Node, 0, len(node.Content))
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		value := node.Content[i+1]
+		if value.Kind == yaml.ScalarNode && (value.Value == "" || value.Value == "0" || value.Value == "false") {
+			continue
+		}
+		if (value.Kind == yaml.MappingNode || value.Kind == yaml.SequenceNode) && len(value.Content) == 0 {
+			continue
+		}
+		content = append(content, node.Content[i], value)
+	}
+	node.Content = content
+}
+
+func sortedRecords(records map[string]*Record) []*Record {
+	sorted := make([]*Record, 0, len(records))
+	for _, r := range records {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].Record < sorted[b].Record })
+	return sorted
+}
+
+// writeRecordTree prints a node of the tree per line, the children are indented under their parent.
+func writeRecordTree(out io.Writer, records map[string]*Record) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	var walk func(records map[string]*Record, depth int)
+	walk = func(records map[string]*Record, depth int) {
+		for _, r := range sortedRecords(records) {
+			name := strings.TrimSuffix(r.Record+"."+r.Domain, ".")
+			if len(r.Value) > 0 {
+				fmt.Fprintf(w, "%s%s\t%s\t%s\n", strings.Repeat("  ", depth), name, r.Type, strings.Join(r.Value, ","))
+			} else {
+				fmt.Fprintf(w, "%s%s\t\t\n", strings.Repeat("  ", depth), name)
+			}
+			walk(r.Children, depth+1)
+		}
+	}
+	walk(records, 0)
+	w.Flush()
+}
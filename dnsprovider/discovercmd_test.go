@@ -0,0 +1,246 @@
+package dnsprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"tlsprobe/autodiscover"
+	"tlsprobe/common/creator"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fakeDryRunner is an autoDiscover whose records are fixed.
+type fakeDryRunner struct {
+	cfg    *autodiscover.Config
+	domain *Domain
+	err    error
+}
+
+func (a *fakeDryRunner) Start() error                             { return nil }
+func (a *fakeDryRunner) Stop() error                              { return nil }
+func (a *fakeDryRunner) Config() *autodiscover.Config             { return a.cfg }
+func (a *fakeDryRunner) Key() string                              { return a.cfg.Key() }
+func (a *fakeDryRunner) Status() autodiscover.Status              { return autodiscover.Status{} }
+func (a *fakeDryRunner) Refresh() error                           { return nil }
+func (a *fakeDryRunner) Records() json.Marshaler                  { return a.domain }
+func (a *fakeDryRunner) GetCreator() creator.Creator              { return nil }
+func (a *fakeDryRunner) Describe() string                         { return a.cfg.Key() }
+func (a *fakeDryRunner) FetchDomain() (*Domain, error)            { return a.domain, a.err }
+func (a *fakeDryRunner) withoutDryRun() autodiscover.AutoDiscover { return &fakeAutoDiscover{a} }
+
+// fakeAutoDiscover hides FetchDomain of the fakeDryRunner.
+type fakeAutoDiscover struct {
+	a *fakeDryRunner
+}
+
+func (a *fakeAutoDiscover) Start() error                 { return nil }
+func (a *fakeAutoDiscover) Stop() error                  { return nil }
+func (a *fakeAutoDiscover) Config() *autodiscover.Config { return a.a.cfg }
+func (a *fakeAutoDiscover) Key() string                  { return a.a.Key() }
+func (a *fakeAutoDiscover) Status() autodiscover.Status  { return autodiscover.Status{} }
+func (a *fakeAutoDiscover) Refresh() error               { return nil }
+func (a *fakeAutoDiscover) Records() json.Marshaler      { return a.a.domain }
+func (a *fakeAutoDiscover) GetCreator() creator.Creator  { return nil }
+func (a *fakeAutoDiscover) Describe() string             { return a.a.Describe() }
+
+func newTestDomain(t *testing.T) *Domain {
+	domain := NewDomain()
+	for _, r := range []struct {
+		fqdn  string
+		value string
+		typ   RecordType
+	}{
+		{"example.com", "1.1.1.1", RecordTypeA},
+		{"www.example.com", "1.1.1.1", RecordTypeA},
+		{"api.example.com", "lb.example.net", RecordTypeCName},
+	} {
+		if _, err := domain.Add(r.fqdn, r.value, r.typ); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return domain
+}
+
+func TestOmitZeroFields(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"zero scalars", "a: 1\nb: 0\nc: \"\"\nd: false\n", "a: 1\n"},
+		{"empty collections", "a: x\nb: {}\nc: []\n", "a: x\n"},
+		{"nested", "a:\n  b: 0\n  c: x\n", "a:\n    c: x\n"},
+		{"emptied mapping", "a:\n  b: 0\nc: true\n", "c: true\n"},
+		{"sequence", "a:\n  - b: x\n    c: 0\n", "a:\n    - b: x\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var node yaml.Node
+			if err := yaml.Unmarshal([]byte(tt.in), &node); err != nil {
+				t.Fatal(err)
+			}
+			omitZeroFields(node.Content[0])
+			out, err := yaml.Marshal(&node)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.want {
+				t.Fatalf("omitZeroFields of %q should be %q, but now is: %q", tt.in, tt.want, out)
+			}
+		})
+	}
+}
+
+func TestWriteRecordTree(t *testing.T) {
+	tests := []struct {
+		name   string
+		domain func(t *testing.T) *Domain
+		want   string
+	}{
+		{"empty", func(t *testing.T) *Domain { return NewDomain() }, ""},
+		{"tree", newTestDomain, "com\n" +
+			"  example.com        A      1.1.1.1\n" +
+			"    api.example.com  CNAME  lb.example.net\n" +
+			"    www.example.com  A      1.1.1.1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			writeRecordTree(&out, tt.domain(t).Records)
+			// the records without values are padded by the tabwriter.
+			lines := strings.Split(out.String(), "\n")
+			for i := range lines {
+				lines[i] = strings.TrimRight(lines[i], " ")
+			}
+			if tree := strings.Join(lines, "\n"); tree != tt.want {
+				t.Fatalf("record tree should be:\n%s\nbut now is:\n%s", tt.want, tree)
+			}
+		})
+	}
+}
+
+func TestRunDiscoverCommand(t *testing.T) {
+	fetchErr := errors.New("fetch failed")
+	autodiscover.RegisterCreator("FakeDryRun", func(ctx context.Context, cfg *autodiscover.Config, c creator.Creator) (autodiscover.AutoDiscover, error) {
+		a := &fakeDryRunner{cfg: cfg, domain: newTestDomain(t)}
+		switch cfg.Options["mode"] {
+		case "error":
+			a.err = fetchErr
+		case "noDryRun":
+			return a.withoutDryRun(), nil
+		}
+		return a, nil
+	})
+
+	configFilename := filepath.Join(t.TempDir(), "config.yml")
+	config := `
+autoDiscover:
+  - name: fake
+    type: FakeDryRun
+    ports: "443"
+    labels:
+      env: test
+  - name: broken
+    type: FakeDryRun
+    options:
+      mode: error
+  - name: noDryRun
+    type: FakeDryRun
+    options:
+      mode: noDryRun
+`
+	if err := os.WriteFile(configFilename, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout []string
+		stderr string
+	}{
+		{"success", []string{"-c", configFilename, "fake"}, 0, []string{
+			"records: 3\n",
+			"\nhostScanners: 3\n",
+			"hostScannersConfig:\n",
+			"host: 1.1.1.1\n",
+			"host: lb.example.net\n",
+			"domain: api.example.com\n",
+			"ports: \"443\"\n",
+			"env: test\n",
+		}, ""},
+		{"fetch error", []string{"--config", configFilename, "broken"}, 1, []string{"records: 3\n", "hostScanners: 3\n"}, "fetch failed"},
+		{"no dry run", []string{"-c", configFilename, "noDryRun"}, 1, nil, "does not support dry run"},
+		{"unknown name", []string{"-c", configFilename, "unknown"}, 1, nil, "autoDiscover unknown not found"},
+		{"missing config", []string{"-c", filepath.Join(t.TempDir(), "missing.yml"), "fake"}, 1, nil, "missing.yml"},
+		{"missing name", []string{"-c", configFilename}, 2, nil, "Usage: tlsprobe discover"},
+		{"unknown flag", []string{"--unknown", "fake"}, 2, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := RunDiscoverCommand(tt.args, &stdout, &stderr); code != tt.code {
+				t.Fatalf("exit code should be %d, but now is: %d, stderr: %s", tt.code, code, stderr.String())
+			}
+			for _, want := range tt.stdout {
+				if !strings.Contains(stdout.String(), want) {
+					t.Fatalf("stdout should contain %q, but now is:\n%s", want, stdout.String())
+				}
+			}
+			if tt.stdout == nil && stdout.Len() != 0 {
+				t.Fatalf("stdout should be empty, but now is:\n%s", stdout.String())
+			}
+			if !strings.Contains(stderr.String(), tt.stderr) {
+				t.Fatalf("stderr should contain %q, but now is: %s", tt.stderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestRunDiscoverCommandSnippet(t *testing.T) {
+	autodiscover.RegisterCreator("FakeDryRunSnippet", func(ctx context.Context, cfg *autodiscover.Config, c creator.Creator) (autodiscover.AutoDiscover, error) {
+		domain := NewDomain()
+		// the record has both a value and children, GetRealRecords returns it twice.
+		if _, err := domain.Add("example.com", "1.1.1.1", RecordTypeA); err != nil {
+			return nil, err
+		}
+		if _, err := domain.Add("www.example.com", "2.2.2.2", RecordTypeA); err != nil {
+			return nil, err
+		}
+		return &fakeDryRunner{cfg: cfg, domain: domain}, nil
+	})
+	configFilename := filepath.Join(t.TempDir(), "config.yml")
+	config := "autoDiscover:\n  - name: fake\n    type: FakeDryRunSnippet\n    module: default\n"
+	if err := os.WriteFile(configFilename, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := RunDiscoverCommand([]string{"-c", configFilename, "fake"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code should be 0, but now is: %d, stderr: %s", code, stderr.String())
+	}
+	_, snippet, found := strings.Cut(stdout.String(), "\nhostScanners: 2\n")
+	if !found {
+		t.Fatalf("the duplicated record should be printed once, but now is:\n%s", stdout.String())
+	}
+	want := `hostScannersConfig:
+    - host: 1.1.1.1
+      TLSOptions:
+        domain: example.com
+      module: default
+    - host: 2.2.2.2
+      TLSOptions:
+        domain: www.example.com
+      module: default
+`
+	if snippet != want {
+		t.Fatalf("snippet should be:\n%s\nbut now is:\n%s", want, snippet)
+	}
+}
@@ -2,6 +2,7 @@ package dnspod
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/rs/zerolog/log"
@@ -53,6 +54,13 @@ type DNSProvider struct {
 	domains     *dnsprovider.Domain
 	lastDomains *dnsprovider.Domain
 	cfg         *autodiscover.Config
+	status      *autodiscover.StatusRecorder
+	// refreshErr is the last error of the current refresh.
+	refreshErr error
+	// refreshCh triggers a refresh out of the schedule.
+	refreshCh chan struct{}
+	// dryRun only fetches the records, no hostScanner is made or removed.
+	dryRun bool
 }
 
 func NewDNSProvider(parentCtx context.Context, name string, cli *dnspod.Client, cfg *autodiscover.Config, creator creator.Creator) *DNSProvider {
@@ -68,6 +76,8 @@ func NewDNSProvider(parentCtx context.Context, name string, cli *dnspod.Client,
 		domains:    dnsprovider.NewDomain(),
 		cfg:        cfg,
 		Creator:    creator,
+		status:     autodiscover.NewStatusRecorder(),
+		refreshCh:  make(chan struct{}, 1),
 	}
 }
 
@@ -103,6 +113,7 @@ func (p *DNSProvider) GetDomains() {
 	// switch domains to lastDomains and reset domains.
 	p.lastDomains = p.domains
 	p.domains = dnsprovider.NewDomain()
+	p.refreshErr = nil
 	// start to fetch domains.
 	for ; ; pageNum++ {
 		// handle stop event.
@@ -131,6 +142,7 @@ func (p *DNSProvider) GetDomains() {
 		// out of retryTimes break.
 		if err != nil {
 			log.Warn().Msgf("DNSProvider %s starting get domains failed: out of retry times", p.Name)
+			p.refreshErr = err
 			break
 		}
 		log.Debug().Msgf("DNSProvider %s got domains: %d", p.Name, len(resp.Response.DomainList))
@@ -150,7 +162,15 @@ func (p *DNSProvider) GetDomains() {
 			break
 		}
 	}
-	dnsprovider.RefreshResources(p.lastDomains.Records, p.domains.Records)
+	if !p.dryRun {
+		dnsprovider.RefreshResources(p.lastDomains.Records, p.domains.Records)
+		// the incomplete records must not replace the saved ones.
+		if p.refreshErr == nil {
+			dnsprovider.SaveDomain(p.Name, p.domains)
+		}
+	}
+	p.status.Record(p.refreshErr, p.domains.Count())
+	p.status.SetRecords(p.domains)
 }
 
 func (p *DNSProvider) getDomainRecords(domainName string) {
@@ -184,6 +204,7 @@ func (p *DNSProvider) getDomainRecords(domainName string) {
 		// out of retryTimes break.
 		if err != nil {
 			log.Warn().Msgf("DNSProvider %s starting get domains failed: out of retry times", p.Name)
+			p.refreshErr = err
 			break
 		}
 		log.Debug().Msgf("DNSProvider %s got domain %s records: %d", p.Name, domainName, len(resp.Response.RecordList))
@@ -197,7 +218,9 @@ func (p *DNSProvider) getDomainRecords(domainName string) {
 				log.Debug().Msgf("add to domains error: %v", err)
 				continue
 			}
-			dnsprovider.MakeHostScanner(p.ctx, record, p)
+			if !p.dryRun {
+				dnsprovider.MakeHostScanner(p.ctx, record, p.cfg, p)
+			}
 		}
 		log.Debug().Msgf(
 			"get domain %s records total count: %d, offset: %d, next offset: %d",
@@ -212,8 +235,17 @@ func (p *DNSProvider) getDomainRecords(domainName string) {
 	}
 }
 
+// FetchDomain fetches all records once without making hostScanners, the DNSProvider should not be started.
+func (p *DNSProvider) FetchDomain() (*dnsprovider.Domain, error) {
+	p.dryRun = true
+	p.GetDomains()
+	return p.domains, p.refreshErr
+}
+
 func (p *DNSProvider) Start() error {
 	go func() {
+		p.domains = dnsprovider.RestoreDomain(p.ctx, p.cfg, p)
+		p.status.SetRecords(p.domains)
 		p.GetDomains()
 		for {
 			select {
@@ -221,6 +253,8 @@ func (p *DNSProvider) Start() error {
 				return
 			case <-time.After(10 * time.Minute):
 				p.GetDomains()
+			case <-p.refreshCh:
+				p.GetDomains()
 			}
 		}
 	}()
@@ -242,6 +276,23 @@ func (p *DNSProvider) Key() string {
 	return p.Config().Key()
 }
 
+func (p *DNSProvider) Status() autodiscover.Status {
+	return p.status.Status()
+}
+
+// Refresh triggers a refresh, it is ignored when a refresh is already pending.
+func (p *DNSProvider) Refresh() error {
+	select {
+	case p.refreshCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (p *DNSProvider) Records() json.Marshaler {
+	return p.status.Records()
+}
+
 func (p *DNSProvider) GetCreator() creator.Creator {
 	return p.Creator
 }
@@ -1,6 +1,7 @@
 package dnsprovider
 
 import (
+	"encoding/json"
 	"errors"
 	"strings"
 	"sync"
@@ -68,6 +69,35 @@ func (d *Domain) Add(fqdn string, recordValue string, recordType RecordType) (*R
 	return rc, nil
 }
 
+// MarshalJSON encodes the record tree.
+func (d *Domain) MarshalJSON() ([]byte, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	return json.Marshal(d.Records)
+}
+
+// Count returns the count of the records which have values.
+func (d *Domain) Count() int {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	count := 0
+	for _, r := range d.Records {
+		count += countRecords(r)
+	}
+	return count
+}
+
+func countRecords(record *Record) int {
+	count := 0
+	if len(record.Value) > 0 {
+		count++
+	}
+	for _, r := range record.Children {
+		count += countRecords(r)
+	}
+	return count
+}
+
 func (d *Domain) Search(fqdn string) *Record {
 	d.mux.RLock()
 	defer d.mux.RUnlock()
@@ -15,7 +15,7 @@ func TestDomainAdd(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if domain.Records["com"].Children["baidu"].Children["xiaoshuo"].Children["1"].Value != "1.1.1.1" {
+	if domain.Records["com"].Children["baidu"].Children["xiaoshuo"].Children["1"].Value[0] != "1.1.1.1" {
 		t.FailNow()
 	}
 	if record.Domain != "xiaoshuo.baidu.com" {
@@ -37,7 +37,7 @@ func TestDomainSearch(t *testing.T) {
 	if record == nil {
 		t.Fatalf("search failed")
 	}
-	if record.Children["1"].Value != "1.1.1.1" {
+	if record.Children["1"].Value[0] != "1.1.1.1" {
 		t.Fatalf("wrong value for record")
 	}
 	fmt.Println(record.Children["1"].Value)
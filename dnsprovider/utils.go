@@ -2,15 +2,25 @@ package dnsprovider
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/rs/zerolog/log"
+	"tlsprobe/autodiscover"
 	"tlsprobe/common"
 	"tlsprobe/common/creator"
 )
 
-func RecordToHostScannerConfig(record *Record) []common.HostScannerConfig {
+// RecordToHostScannerConfig makes a HostScannerConfig for every value of the record.
+// The TLSOptions come from the autoDiscover's module if it is set, and the ports come from its port policy.
+// cfg may be nil when only the keys are used.
+func RecordToHostScannerConfig(record *Record, cfg *autodiscover.Config) []common.HostScannerConfig {
 	cfgs := make([]common.HostScannerConfig, len(record.Value))
+	// the ownership labels of the domain override the labels of the autoDiscover.
+	labels := common.Labels(nil).Merge(common.Exp.OwnershipLabels(GetFQDN(record)))
+	if cfg != nil {
+		labels = common.Labels(cfg.Labels).Merge(labels)
+	}
 	for i, v := range record.Value {
-		cfg := common.HostScannerConfig{
+		hostScannerConfig := common.HostScannerConfig{
 			Host: v,
 			TLSOptions: common.TLSCheckOptions{
 				Domain:             GetFQDN(record),
@@ -19,23 +29,25 @@ func RecordToHostScannerConfig(record *Record) []common.HostScannerConfig {
 				ReTryTimes:         3,
 			},
 		}
-		cfgs[i] = cfg
+		if cfg != nil && cfg.Module != "" {
+			hostScannerConfig.Module = cfg.Module
+			hostScannerConfig.TLSOptions = common.TLSCheckOptions{
+				Domain: GetFQDN(record),
+			}
+		}
+		if cfg != nil {
+			hostScannerConfig.Ports = cfg.Ports
+			hostScannerConfig.ExcludePorts = cfg.ExcludePorts
+		}
+		hostScannerConfig.Labels = labels
+		cfgs[i] = hostScannerConfig
 	}
 	return cfgs
 }
 
-func MakeHostScanner(ctx context.Context, record *Record, creator creator.Creator) {
-	for _, v := range record.Value {
-		cfg := common.HostScannerConfig{
-			Host: v,
-			TLSOptions: common.TLSCheckOptions{
-				Domain:             GetFQDN(record),
-				Timeout:            10000,
-				InsecureSkipVerify: true,
-				ReTryTimes:         3,
-			},
-		}
-		common.Exp.UpdateHostScannerConfig(ctx, &cfg, creator)
+func MakeHostScanner(ctx context.Context, record *Record, cfg *autodiscover.Config, creator creator.Creator) {
+	for _, hostScannerConfig := range RecordToHostScannerConfig(record, cfg) {
+		common.Exp.UpdateHostScannerConfig(ctx, &hostScannerConfig, creator)
 	}
 }
 
@@ -61,7 +73,7 @@ func GetRealRecords(record *Record) []*Record {
 func RefreshResources(oldRecords, newRecords map[string]*Record) {
 	records := GetShouldRefreshRecords(oldRecords, newRecords)
 	for _, record := range records {
-		for _, cfg := range RecordToHostScannerConfig(record) {
+		for _, cfg := range RecordToHostScannerConfig(record, nil) {
 			log.Info().Msgf("GetShouldRefreshRecords should delete hostScanner: %s", cfg.Key())
 			common.Exp.RemoveHostScanner(cfg.Key())
 		}
@@ -89,3 +101,41 @@ func GetShouldRefreshRecords(old, newRecords map[string]*Record) (shouldDeleteRe
 	}
 	return
 }
+
+// RestoreDomain restores the records of the autoDiscover from the state file and makes their hostScanners,
+// so the known endpoints are probed before the first refresh is finished.
+func RestoreDomain(ctx context.Context, cfg *autodiscover.Config, creator creator.Creator) *Domain {
+	domain := NewDomain()
+	raw := common.State.AutoDiscoverRecords(cfg.Name)
+	if raw == nil {
+		return domain
+	}
+	if err := json.Unmarshal(raw, &domain.Records); err != nil {
+		log.Warn().Msgf("restore records of autoDiscover %s failed: %v", cfg.Name, err)
+		return NewDomain()
+	}
+	count := 0
+	for _, r := range domain.Records {
+		for _, record := range GetRealRecords(r) {
+			MakeHostScanner(ctx, record, cfg, creator)
+			count++
+		}
+	}
+	log.Info().Msgf("restored %d records of autoDiscover %s", count, cfg.Name)
+	return domain
+}
+
+// SaveDomain saves the records of the autoDiscover to the state.
+func SaveDomain(name string, domain *Domain) {
+	if !common.State.Enabled() {
+		return
+	}
+	domain.mux.RLock()
+	raw, err := json.Marshal(domain.Records)
+	domain.mux.RUnlock()
+	if err != nil {
+		log.Warn().Msgf("save records of autoDiscover %s failed: %v", name, err)
+		return
+	}
+	common.State.SetAutoDiscoverRecords(name, raw)
+}
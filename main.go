@@ -8,9 +8,12 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/pflag"
 	"go.uber.org/automaxprocs/maxprocs"
+	"io"
 	"net/http"
+	"os"
 	"tlsprobe/autodiscover"
 	"tlsprobe/common"
+	"tlsprobe/dnsprovider"
 	aliyundnsprovider "tlsprobe/dnsprovider/aliyun"
 	dnspoddnsprovider "tlsprobe/dnsprovider/dnspod"
 	"runtime"
@@ -22,6 +25,11 @@ var logLevel = pflag.StringP("level", "v", "info", "log level like trace/debug/i
 var health bool
 
 func init() {
+	// the subcommands parse their own flags.
+	if _, exists := subcommands[subcommand()]; exists {
+		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+		return
+	}
 	pflag.Parse()
 	switch *logLevel {
 	case "trace":
@@ -38,7 +46,28 @@ func init() {
 
 }
 
+// subcommands run once and exit without the exporter.
+var subcommands = map[string]func(args []string, stdout io.Writer, stderr io.Writer) int{
+	"check":    common.RunCheckCommand,
+	"scan":     common.RunScanCommand,
+	"discover": dnsprovider.RunDiscoverCommand,
+}
+
+func subcommand() string {
+	if len(os.Args) > 1 {
+		return os.Args[1]
+	}
+	return ""
+}
+
 func main() {
+	// registry autoDiscover Creator
+	autodiscover.RegisterCreator("AliDNS", aliyundnsprovider.Creator)
+	autodiscover.RegisterCreator("DNSPod", dnspoddnsprovider.Creator)
+
+	if run, exists := subcommands[subcommand()]; exists {
+		os.Exit(run(os.Args[2:], os.Stdout, os.Stderr))
+	}
 
 	// Automatically set GOMAXPROCS to match Linux container CPU quota
 	if _, err := maxprocs.Set(maxprocs.Logger(log.Info().Msgf)); err != nil {
@@ -51,10 +80,6 @@ func main() {
 	defer cancelFunc()
 	common.SetupSignalHandler(cancelFunc)
 
-	// registry autoDiscover Creator
-	autodiscover.RegisterCreator("AliDNS", aliyundnsprovider.Creator)
-	autodiscover.RegisterCreator("DNSPod", dnspoddnsprovider.Creator)
-
 	// init config reloader
 	reloader := common.NewReloader(ctx, *configFilename)
 	if err := reloader.LoadConfig(*configFilename); err != nil {
@@ -77,6 +102,11 @@ func main() {
 		}
 	})
 	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/probe", common.ProbeHandler)
+	mux.Handle("/api/v1/", common.APIHandler())
+	mux.Handle("/api/v1/silences", common.Exp.Admin.SilencesHandler())
+	mux.Handle("/api/v1/admin/", common.Exp.Admin.Handler())
+	mux.Handle("/dashboard/", common.DashboardHandler())
 
 	if err := http.ListenAndServe(reloader.Config.ListenAddr, mux); err != nil {
 		panic(err)
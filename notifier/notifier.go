@@ -0,0 +1,176 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+var registeredCreator map[string]Creator = make(map[string]Creator)
+
+type Creator func(*SinkConfig) (Sink, error)
+
+var ErrorNotFoundSinkCreator error = errors.New("not Found Sink Creator")
+
+// Sink sends the notifications to a receiver, like a webhook or an IM robot.
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, n *Notification) error
+}
+
+const (
+	AlertStatusFiring   = "firing"
+	AlertStatusResolved = "resolved"
+)
+
+// Alert is a certificate expiry or a probe failure of an endpoint.
+type Alert struct {
+	// Key identifies the alert, it is the TLSChecker key and the alert name.
+	Key      string `json:"key"`
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Severity string `json:"severity"`
+	Host     string `json:"host"`
+	Port     uint   `json:"port"`
+	Domain   string `json:"domain"`
+	Summary  string `json:"summary"`
+	// NotAfter is the NotAfter of the leaf certificate, it is zero when the probe failed.
+	NotAfter time.Time `json:"notAfter,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	StartsAt time.Time `json:"startsAt"`
+	EndsAt   time.Time `json:"endsAt,omitempty"`
+}
+
+// Notification is the alerts sent to the sinks in one batch.
+type Notification struct {
+	Alerts []*Alert `json:"alerts"`
+}
+
+// Config is the `notifiers:` section of the config file.
+type Config struct {
+	// RepeatInterval is the seconds before a firing alert is sent again, default is 14400.
+	RepeatInterval uint `yaml:"repeatInterval"`
+	// DisableResolved disables the notifications when the alerts are resolved.
+	DisableResolved bool `yaml:"disableResolved"`
+	// FailureThreshold is the consecutive failed probes before a probe failure fires, default is 1.
+	FailureThreshold uint `yaml:"failureThreshold"`
+	// ProbeInterval is the seconds between probing all TLSCheckers for the notifiers,
+	// 0 means the probe results come from the scrapes of /metrics only.
+	ProbeInterval uint         `yaml:"probeInterval"`
+	Sinks         []SinkConfig `yaml:"sinks"`
+}
+
+func (c *Config) GetRepeatInterval() time.Duration {
+	if c.RepeatInterval == 0 {
+		return 4 * time.Hour
+	}
+	return time.Duration(c.RepeatInterval) * time.Second
+}
+
+func (c *Config) GetFailureThreshold() uint {
+	if c.FailureThreshold == 0 {
+		return 1
+	}
+	return c.FailureThreshold
+}
+
+func (c *Config) GetProbeInterval() time.Duration {
+	return time.Duration(c.ProbeInterval) * time.Second
+}
+
+// Validate checks the sink names are set and unique, the sent state of the alerts is kept per sink name.
+func (c *Config) Validate() error {
+	names := make(map[string]struct{}, len(c.Sinks))
+	for i, sink := range c.Sinks {
+		if sink.Name == "" {
+			return fmt.Errorf("notifier sink %d has no name", i)
+		}
+		if _, exists := names[sink.Name]; exists {
+			return fmt.Errorf("notifier sink name %s is duplicated", sink.Name)
+		}
+		names[sink.Name] = struct{}{}
+	}
+	return nil
+}
+
+func (c *Config) CompareConfig(b *Config) bool {
+	return reflect.DeepEqual(c, b)
+}
+
+type SinkConfig struct {
+	Name string `yaml:"name"`
+	// Type is one of webhook, dingtalk, wecom, feishu and slack.
+	Type string `yaml:"type"`
+	URL  string `yaml:"url"`
+	// Secret signs the requests of the DingTalk and Feishu robots.
+	Secret string `yaml:"secret"`
+	// Headers are the extra HTTP headers of the webhook.
+	Headers map[string]string `yaml:"headers"`
+	// Timeout is the milliseconds of a request, default is 5000.
+	Timeout uint `yaml:"timeout"`
+}
+
+func (c *SinkConfig) GetTimeout() time.Duration {
+	if c.Timeout == 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(c.Timeout) * time.Millisecond
+}
+
+func RegisterCreator(typeName string, creator Creator) {
+	registeredCreator[typeName] = creator
+}
+
+func CreateSink(cfg *SinkConfig) (Sink, error) {
+	c, exists := registeredCreator[cfg.Type]
+	if !exists {
+		return nil, fmt.Errorf("CreateSink type: %s :%w", cfg.Type, ErrorNotFoundSinkCreator)
+	}
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("sink %s has no url", cfg.Name)
+	}
+	return c(cfg)
+}
+
+func init() {
+	RegisterCreator("webhook", NewWebhookSink)
+	RegisterCreator("dingtalk", NewDingTalkSink)
+	RegisterCreator("wecom", NewWeComSink)
+	RegisterCreator("feishu", NewFeishuSink)
+	RegisterCreator("slack", NewSlackSink)
+}
+
+// postJSON posts body to url, the response body is returned when the status code is 2xx.
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body interface{}) ([]byte, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("post %s got status %d: %s", url, resp.StatusCode, respBody)
+	}
+	return respBody, nil
+}
@@ -0,0 +1,88 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestReceiver returns a receiver which records the last request and responds with response.
+func newTestReceiver(t *testing.T, response string) (*httptest.Server, *http.Request, *[]byte) {
+	var last http.Request
+	body := make([]byte, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = *r
+		body, _ = io.ReadAll(r.Body)
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	return server, &last, &body
+}
+
+func TestSinks(t *testing.T) {
+	n := &Notification{Alerts: []*Alert{{
+		Key:      "TLSChecker addr: 127.0.0.1:443, domain: www.example.com/CertificateExpiry",
+		Name:     "CertificateExpiry",
+		Status:   AlertStatusFiring,
+		Severity: "warning",
+		Host:     "127.0.0.1",
+		Port:     443,
+		Domain:   "www.example.com",
+		Summary:  "certificate expires in 10 days",
+	}}}
+	cases := []struct {
+		cfg      SinkConfig
+		response string
+		contains []string
+		err      bool
+	}{
+		{SinkConfig{Type: "webhook", Headers: map[string]string{"Authorization": "Bearer token"}}, "", []string{`"name":"CertificateExpiry"`, `"severity":"warning"`}, false},
+		{SinkConfig{Type: "dingtalk", URL: "/robot/send?access_token=abc", Secret: "SEC123"}, `{"errcode":0}`, []string{`"msgtype":"markdown"`, "www.example.com"}, false},
+		{SinkConfig{Type: "dingtalk", URL: "/robot/send?access_token=abc"}, `{"errcode":310000,"errmsg":"sign not match"}`, nil, true},
+		{SinkConfig{Type: "wecom", URL: "/cgi-bin/webhook/send?key=abc"}, `{"errcode":0,"errmsg":"ok"}`, []string{`"content":"### TLSProbe: 1 firing, 0 resolved`}, false},
+		{SinkConfig{Type: "feishu", URL: "/open-apis/bot/v2/hook/abc", Secret: "abc"}, `{"code":0}`, []string{`"msg_type":"text"`, `"sign":`}, false},
+		{SinkConfig{Type: "feishu", URL: "/open-apis/bot/v2/hook/abc"}, `{"code":19021,"msg":"sign match fail"}`, nil, true},
+		{SinkConfig{Type: "slack", URL: "/services/T000/B000/XXX"}, "ok", []string{`"text":"TLSProbe: 1 firing, 0 resolved\n[FIRING] CertificateExpiry 127.0.0.1:443`}, false},
+	}
+	for _, c := range cases {
+		server, req, body := newTestReceiver(t, c.response)
+		cfg := c.cfg
+		cfg.Name = c.cfg.Type
+		cfg.URL = server.URL + cfg.URL
+		sink, err := CreateSink(&cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = sink.Send(context.Background(), n)
+		if (err != nil) != c.err {
+			t.Fatalf("%s: error should be %v, but now is: %v", cfg.Type, c.err, err)
+		}
+		for _, s := range c.contains {
+			if !strings.Contains(string(*body), s) {
+				t.Fatalf("%s: body should contain %s, but now is: %s", cfg.Type, s, *body)
+			}
+		}
+		if !json.Valid(*body) {
+			t.Fatalf("%s: body should be json, but now is: %s", cfg.Type, *body)
+		}
+		switch cfg.Type {
+		case "webhook":
+			if auth := req.Header.Get("Authorization"); auth != "Bearer token" {
+				t.Fatalf("webhook should send the headers, but now Authorization is: %s", auth)
+			}
+		case "dingtalk":
+			query := req.URL.Query()
+			if query.Get("access_token") != "abc" || (cfg.Secret != "" && query.Get("sign") == "") {
+				t.Fatalf("dingtalk should keep the access_token and sign the request, but now is: %s", req.URL)
+			}
+		}
+	}
+
+	if _, err := CreateSink(&SinkConfig{Name: "unknown", Type: "email", URL: "smtp://localhost"}); err == nil {
+		t.Fatal("create sink of unknown type should fail")
+	}
+}
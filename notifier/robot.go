@@ -0,0 +1,171 @@
+package notifier
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RobotSink sends the Notification as a markdown or text message to the incoming webhook of an IM robot,
+// DingTalk, WeCom, Feishu and Slack only differ in the message body and the response.
+type RobotSink struct {
+	cfg    *SinkConfig
+	client *http.Client
+	// markdown renders the text with markdown.
+	markdown bool
+	// request returns the url and the body of the message.
+	request func(cfg *SinkConfig, title string, text string) (string, interface{})
+	// check returns an error when the response body reports a failure.
+	check func(body []byte) error
+}
+
+func newRobotSink(cfg *SinkConfig, markdown bool, request func(*SinkConfig, string, string) (string, interface{}), check func([]byte) error) *RobotSink {
+	return &RobotSink{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: cfg.GetTimeout()},
+		markdown: markdown,
+		request:  request,
+		check:    check,
+	}
+}
+
+func (s *RobotSink) Name() string {
+	return s.cfg.Name
+}
+
+func (s *RobotSink) Send(ctx context.Context, n *Notification) error {
+	title, text := Render(n, s.markdown)
+	u, body := s.request(s.cfg, title, text)
+	respBody, err := postJSON(ctx, s.client, u, s.cfg.Headers, body)
+	if err != nil {
+		return err
+	}
+	if s.check != nil {
+		return s.check(respBody)
+	}
+	return nil
+}
+
+// Render returns the title and the text of the Notification.
+func Render(n *Notification, markdown bool) (string, string) {
+	firing, resolved := 0, 0
+	for _, a := range n.Alerts {
+		if a.Status == AlertStatusFiring {
+			firing++
+		} else {
+			resolved++
+		}
+	}
+	title := fmt.Sprintf("TLSProbe: %d firing, %d resolved", firing, resolved)
+	lines := make([]string, 0, len(n.Alerts)+1)
+	if markdown {
+		lines = append(lines, "### "+title)
+	} else {
+		lines = append(lines, title)
+	}
+	for _, a := range n.Alerts {
+		line := fmt.Sprintf("[%s] %s %s:%d (%s): %s", strings.ToUpper(a.Status), a.Name, a.Host, a.Port, a.Domain, a.Summary)
+		if markdown {
+			line = "- " + line
+		}
+		lines = append(lines, line)
+	}
+	return title, strings.Join(lines, "\n")
+}
+
+// errcodeResponse is the response of DingTalk and WeCom.
+type errcodeResponse struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+func checkErrcode(body []byte) error {
+	resp := &errcodeResponse{}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return fmt.Errorf("invalid response %s: %w", body, err)
+	}
+	if resp.ErrCode != 0 {
+		return fmt.Errorf("robot response errcode: %d, errmsg: %s", resp.ErrCode, resp.ErrMsg)
+	}
+	return nil
+}
+
+// hmacSign returns the base64 HMAC-SHA256 of message.
+func hmacSign(key string, message string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(message))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// NewDingTalkSink sends markdown messages to a DingTalk robot,
+// the requests are signed when Secret is set.
+func NewDingTalkSink(cfg *SinkConfig) (Sink, error) {
+	return newRobotSink(cfg, true, func(cfg *SinkConfig, title string, text string) (string, interface{}) {
+		u := cfg.URL
+		if parsed, err := url.Parse(cfg.URL); err == nil && cfg.Secret != "" {
+			timestamp := fmt.Sprintf("%d", time.Now().UnixMilli())
+			query := parsed.Query()
+			query.Set("timestamp", timestamp)
+			query.Set("sign", hmacSign(cfg.Secret, timestamp+"\n"+cfg.Secret))
+			parsed.RawQuery = query.Encode()
+			u = parsed.String()
+		}
+		return u, map[string]interface{}{
+			"msgtype":  "markdown",
+			"markdown": map[string]string{"title": title, "text": text},
+		}
+	}, checkErrcode), nil
+}
+
+// NewWeComSink sends markdown messages to a WeCom group robot.
+func NewWeComSink(cfg *SinkConfig) (Sink, error) {
+	return newRobotSink(cfg, true, func(cfg *SinkConfig, title string, text string) (string, interface{}) {
+		return cfg.URL, map[string]interface{}{
+			"msgtype":  "markdown",
+			"markdown": map[string]string{"content": text},
+		}
+	}, checkErrcode), nil
+}
+
+// NewFeishuSink sends text messages to a Feishu robot,
+// the requests are signed when Secret is set.
+func NewFeishuSink(cfg *SinkConfig) (Sink, error) {
+	return newRobotSink(cfg, false, func(cfg *SinkConfig, title string, text string) (string, interface{}) {
+		body := map[string]interface{}{
+			"msg_type": "text",
+			"content":  map[string]string{"text": text},
+		}
+		if cfg.Secret != "" {
+			timestamp := fmt.Sprintf("%d", time.Now().Unix())
+			body["timestamp"] = timestamp
+			body["sign"] = hmacSign(timestamp+"\n"+cfg.Secret, "")
+		}
+		return cfg.URL, body
+	}, func(body []byte) error {
+		resp := &struct {
+			Code int    `json:"code"`
+			Msg  string `json:"msg"`
+		}{}
+		if err := json.Unmarshal(body, resp); err != nil {
+			return fmt.Errorf("invalid response %s: %w", body, err)
+		}
+		if resp.Code != 0 {
+			return fmt.Errorf("feishu response code: %d, msg: %s", resp.Code, resp.Msg)
+		}
+		return nil
+	}), nil
+}
+
+// NewSlackSink sends text messages to a Slack incoming webhook, which responds "ok".
+func NewSlackSink(cfg *SinkConfig) (Sink, error) {
+	return newRobotSink(cfg, false, func(cfg *SinkConfig, title string, text string) (string, interface{}) {
+		return cfg.URL, map[string]string{"text": text}
+	}, nil), nil
+}
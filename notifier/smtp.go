@@ -0,0 +1,131 @@
+package notifier
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+const (
+	SMTPTLSStartTLS = "starttls"
+	SMTPTLSImplicit = "tls"
+	SMTPTLSNone     = "none"
+)
+
+// SMTPConfig is the SMTP server used to send emails.
+type SMTPConfig struct {
+	Host string `yaml:"host"`
+	// Port default is 587, or 465 when TLS is "tls".
+	Port     uint     `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	// TLS is one of "starttls", "tls" and "none", default is "starttls", which fails when the server does not support it.
+	TLS                string `yaml:"tls"`
+	InsecureSkipVerify bool   `yaml:"skipVerify"`
+	// Timeout is the milliseconds of the whole session, default is 30000.
+	Timeout uint `yaml:"timeout"`
+}
+
+func (c *SMTPConfig) GetTLS() string {
+	if c.TLS == "" {
+		return SMTPTLSStartTLS
+	}
+	return c.TLS
+}
+
+func (c *SMTPConfig) GetPort() uint {
+	if c.Port != 0 {
+		return c.Port
+	}
+	if c.GetTLS() == SMTPTLSImplicit {
+		return 465
+	}
+	return 587
+}
+
+func (c *SMTPConfig) GetTimeout() time.Duration {
+	if c.Timeout == 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.Timeout) * time.Millisecond
+}
+
+func (c *SMTPConfig) Enabled() bool {
+	return c.Host != "" && c.From != "" && len(c.To) > 0
+}
+
+// SendMail sends a plain text email to all recipients.
+func SendMail(cfg *SMTPConfig, subject string, body string) error {
+	if !cfg.Enabled() {
+		return errors.New("smtp host, from and to are required")
+	}
+	addr := net.JoinHostPort(cfg.Host, fmt.Sprintf("%d", cfg.GetPort()))
+	tlsConfig := &tls.Config{ServerName: cfg.Host, InsecureSkipVerify: cfg.InsecureSkipVerify}
+	conn, err := net.DialTimeout("tcp", addr, cfg.GetTimeout())
+	if err != nil {
+		return fmt.Errorf("connect smtp server %s failed: %w", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(cfg.GetTimeout()))
+	if cfg.GetTLS() == SMTPTLSImplicit {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp server %s greeting failed: %w", addr, err)
+	}
+	defer c.Close()
+	if cfg.GetTLS() == SMTPTLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp server %s does not support STARTTLS", addr)
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("smtp server %s STARTTLS failed: %w", addr, err)
+		}
+	}
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return fmt.Errorf("smtp server %s auth failed: %w", addr, err)
+		}
+	}
+	if err := c.Mail(cfg.From); err != nil {
+		return err
+	}
+	for _, to := range cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("smtp rcpt %s failed: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buildMessage(cfg, subject, body)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func buildMessage(cfg *SMTPConfig, subject string, body string) []byte {
+	headers := []string{
+		"From: " + cfg.From,
+		"To: " + strings.Join(cfg.To, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+	}
+	body = strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n")
+	return []byte(strings.Join(headers, "\r\n") + "\r\n\r\n" + body)
+}
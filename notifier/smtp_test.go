@@ -0,0 +1,157 @@
+package notifier
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSMTPServer is a local SMTP stand-in which supports STARTTLS and AUTH PLAIN.
+type testSMTPServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	// auth is the decoded AUTH PLAIN credentials, rcpts and data are the last mail.
+	auth  string
+	rcpts []string
+	data  string
+	done  chan struct{}
+}
+
+func newTestSMTPServer(t *testing.T) *testSMTPServer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &testSMTPServer{
+		listener:  l,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		done:      make(chan struct{}),
+	}
+	go s.serve()
+	return s
+}
+
+func (s *testSMTPServer) Port() uint {
+	return uint(s.listener.Addr().(*net.TCPAddr).Port)
+}
+
+func (s *testSMTPServer) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+	secure := false
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			if secure {
+				tp.PrintfLine("250-localhost\r\n250 AUTH PLAIN")
+			} else {
+				tp.PrintfLine("250-localhost\r\n250 STARTTLS")
+			}
+		case "STARTTLS":
+			tp.PrintfLine("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			tp = textproto.NewConn(conn)
+			secure = true
+		case "AUTH":
+			fields := strings.Fields(line)
+			decoded, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			s.auth = string(decoded)
+			tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			s.rcpts = append(s.rcpts, line)
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 end with <CRLF>.<CRLF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.data = string(data)
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestSendMail(t *testing.T) {
+	s := newTestSMTPServer(t)
+	cfg := &SMTPConfig{
+		Host:               "127.0.0.1",
+		Port:               s.Port(),
+		Username:           "tlsprobe",
+		Password:           "secret",
+		From:               "tlsprobe@example.com",
+		To:                 []string{"ops@example.com", "cto@example.com"},
+		InsecureSkipVerify: true,
+	}
+	if err := SendMail(cfg, "证书到期报告", "line 1\nline 2"); err != nil {
+		t.Fatal(err)
+	}
+	<-s.done
+	if s.auth != "\x00tlsprobe\x00secret" {
+		t.Fatalf("auth should be sent after STARTTLS, but now is: %q", s.auth)
+	}
+	if len(s.rcpts) != 2 {
+		t.Fatalf("rcpts should be 2, but now is: %v", s.rcpts)
+	}
+	for _, expected := range []string{"To: ops@example.com, cto@example.com", "Subject: =?utf-8?q?", "line 1\nline 2"} {
+		if !strings.Contains(s.data, expected) {
+			t.Fatalf("mail should contain %q, but now is: %s", expected, s.data)
+		}
+	}
+
+	// the server only supports STARTTLS, so implicit TLS fails.
+	cfg.Port = newTestSMTPServer(t).Port()
+	cfg.TLS = SMTPTLSImplicit
+	cfg.Timeout = 1000
+	if err := SendMail(cfg, "report", "body"); err == nil {
+		t.Fatal("implicit TLS to a STARTTLS server should fail")
+	}
+}
@@ -0,0 +1,28 @@
+package notifier
+
+import (
+	"context"
+	"net/http"
+)
+
+// WebhookSink posts the Notification as JSON to a generic webhook.
+type WebhookSink struct {
+	cfg    *SinkConfig
+	client *http.Client
+}
+
+func NewWebhookSink(cfg *SinkConfig) (Sink, error) {
+	return &WebhookSink{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.GetTimeout()},
+	}, nil
+}
+
+func (s *WebhookSink) Name() string {
+	return s.cfg.Name
+}
+
+func (s *WebhookSink) Send(ctx context.Context, n *Notification) error {
+	_, err := postJSON(ctx, s.client, s.cfg.URL, s.cfg.Headers, n)
+	return err
+}